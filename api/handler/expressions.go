@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize and MaxPageSize limit how many expressions GET /api/v1/expressions returns at once.
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// sortKeys are what the expressions can be sorted by, as SQL.
+// Expressions from before the timestamps were kept were created at 0,
+// and the ones that aren't finished yet are finished at the end of time.
+var sortKeys = map[string]string{
+	"id":       "id",
+	"created":  "COALESCE(created_at, 0)",
+	"finished": fmt.Sprintf("COALESCE(finished_at, %d)", int64(math.MaxInt64)),
+}
+
+// expressionsPage is what GET /api/v1/expressions was asked for:
+//
+//	status=Finished,Cancelled      only these statuses
+//	created_after, created_before  RFC 3339 times, the first one included
+//	q=2+2                          the original expression contains this text
+//	sort=-created                  id, created or finished, - for the newest first
+//	limit=50                       how many expressions at most
+//	cursor=...                     where the previous page stopped
+type expressionsPage struct {
+	statuses      []string
+	createdAfter  time.Time
+	createdBefore time.Time
+	search        string
+	sort          string
+	descending    bool
+	limit         int
+	after         *pageCursor
+}
+
+// pageCursor is the last expression of the previous page.
+type pageCursor struct {
+	key int64
+	id  int
+}
+
+func parseExpressionsPage(query url.Values) (expressionsPage, error) {
+	page := expressionsPage{sort: "id", limit: DefaultPageSize}
+
+	for _, statuses := range query["status"] {
+		for _, status := range strings.Split(statuses, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				page.statuses = append(page.statuses, status)
+			}
+		}
+	}
+
+	var err error
+	if value := query.Get("created_after"); value != "" {
+		if page.createdAfter, err = time.Parse(time.RFC3339, value); err != nil {
+			return page, fmt.Errorf("created_after is not an RFC 3339 time: %q", value)
+		}
+	}
+	if value := query.Get("created_before"); value != "" {
+		if page.createdBefore, err = time.Parse(time.RFC3339, value); err != nil {
+			return page, fmt.Errorf("created_before is not an RFC 3339 time: %q", value)
+		}
+	}
+
+	page.search = query.Get("q")
+
+	if value := query.Get("sort"); value != "" {
+		page.sort, page.descending = strings.CutPrefix(value, "-")
+		if _, ok := sortKeys[page.sort]; !ok {
+			return page, fmt.Errorf("can't sort by %q, only by id, created or finished", page.sort)
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		page.limit, err = strconv.Atoi(value)
+		if err != nil || page.limit < 1 || page.limit > MaxPageSize {
+			return page, fmt.Errorf("limit must be from 1 to %d", MaxPageSize)
+		}
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		if page.after, err = page.decodeCursor(value); err != nil {
+			return page, err
+		}
+	}
+
+	return page, nil
+}
+
+// sortOrder is how the page is sorted, the way it's written in the sort parameter.
+func (p expressionsPage) sortOrder() string {
+	if p.descending {
+		return "-" + p.sort
+	}
+	return p.sort
+}
+
+// cursor points right after the expression, so the next page starts there.
+// It only works with the same sorting.
+func (p expressionsPage) cursor(key int64, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d:%d", p.sortOrder(), key, id)))
+}
+
+func (p expressionsPage) decodeCursor(cursor string) (*pageCursor, error) {
+	errBadCursor := errors.New("bad cursor")
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	parts := strings.Split(string(decoded), ":")
+	if len(parts) != 3 {
+		return nil, errBadCursor
+	}
+	if parts[0] != p.sortOrder() {
+		return nil, errors.New("the cursor is for another sort order")
+	}
+
+	key, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, errBadCursor
+	}
+	return &pageCursor{key, id}, nil
+}
+
+// query is the SQL for the page of the user's expressions. It selects the sort key after
+// the usual columns, and one expression more than the limit, to know if there is a next page.
+func (p expressionsPage) query(userId int) (string, []any) {
+	key := sortKeys[p.sort]
+	where := []string{"owner = ?"}
+	args := []any{userId}
+
+	if len(p.statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(p.statuses)-1)+")")
+		for _, status := range p.statuses {
+			args = append(args, status)
+		}
+	}
+	if !p.createdAfter.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, p.createdAfter.Unix())
+	}
+	if !p.createdBefore.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, p.createdBefore.Unix())
+	}
+	if p.search != "" {
+		where = append(where, `original_expression LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(p.search)+"%")
+	}
+
+	order, compare := "ASC", ">"
+	if p.descending {
+		order, compare = "DESC", "<"
+	}
+	if p.after != nil {
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", key, compare))
+		args = append(args, p.after.key, p.after.key, p.after.id)
+	}
+
+	query := fmt.Sprintf(`SELECT id, status, original_expression, expression, result, mode, variables, optimized_expression, created_at, finished_at, owner, %[1]s
+		FROM expressions WHERE %[2]s ORDER BY %[1]s %[3]s, id %[3]s LIMIT ?`, key, strings.Join(where, " AND "), order)
+	return query, append(args, p.limit+1)
+}
+
+// escapeLike makes the text match itself in LIKE, even if it has % or _ in it.
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text)
+}
+
+// formatTimestamp turns a unix time from the database into what the API shows.
+func formatTimestamp(unix *int64) string {
+	if unix == nil {
+		return ""
+	}
+	return time.Unix(*unix, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseExpressionsPage(t *testing.T) {
+	query, _ := url.ParseQuery("status=Finished,Cancelled&status=In+Process&created_after=2024-05-01T00:00:00Z&q=50%25_off&sort=-finished&limit=20")
+	page, err := parseExpressionsPage(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.statuses) != 3 || page.sort != "finished" || !page.descending || page.limit != 20 {
+		t.Fatalf("got %+v", page)
+	}
+
+	sql, args := page.query(7)
+	if !strings.Contains(sql, "status IN (?, ?, ?)") || !strings.Contains(sql, "DESC, id DESC") {
+		t.Errorf("got %s", sql)
+	}
+	if args[0] != 7 || args[5] != `%50\%\_off%` || args[len(args)-1] != 21 {
+		t.Errorf("got %v", args)
+	}
+
+	for _, bad := range []string{"sort=name", "limit=0", "limit=100000", "created_before=yesterday", "cursor=nope"} {
+		query, _ := url.ParseQuery(bad)
+		if _, err := parseExpressionsPage(query); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
+
+func TestPageCursor(t *testing.T) {
+	page := expressionsPage{sort: "created", descending: true}
+	cursor := page.cursor(1714521600, 42)
+
+	after, err := page.decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.key != 1714521600 || after.id != 42 {
+		t.Errorf("got %+v", after)
+	}
+
+	sql, args := expressionsPage{sort: "created", descending: true, limit: 10, after: after}.query(1)
+	if !strings.Contains(sql, "(COALESCE(created_at, 0) < ? OR (COALESCE(created_at, 0) = ? AND id < ?))") {
+		t.Errorf("got %s", sql)
+	}
+	if len(args) != 5 {
+		t.Errorf("got %v", args)
+	}
+
+	if _, err := (expressionsPage{sort: "created"}).decodeCursor(cursor); err == nil {
+		t.Error("a cursor for another sort order was accepted")
+	}
+}
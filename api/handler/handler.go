@@ -1,9 +1,14 @@
 package handler
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	calculate "distributed-calculator/internal/logic"
+	"distributed-calculator/internal/cache"
 	"distributed-calculator/internal/service"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,24 +16,86 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 	"github.com/golang-jwt/jwt/v5"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// LeaseDuration is how long an agent may hold a calculation
+// before it is handed out to somebody else.
+var LeaseDuration = 60 * time.Second
+
+// Errors TakeTask can return, so the gRPC server can tell the agent what went wrong.
 var (
-	db                   *sql.DB
-	tasksMutex           sync.Mutex
-	calculationsMutex    sync.Mutex
-	beingCalculatedMutex sync.Mutex
-	Tasks                = make(map[int]service.Task)
-	Calculations         = []service.Calculation{}
-	BeingCalculated      = []service.Calculation{}
+	ErrBadCalculationStatus = errors.New("calculation status must be Finished or Error")
+	ErrCalculationNotFound  = errors.New("no such calculation")
+	ErrAlreadyReported      = errors.New("calculation result was already reported")
+	ErrTaskFinished         = errors.New("expression is already finished")
+	ErrTaskCancelled        = errors.New("expression was cancelled")
+	ErrNotLeaseHolder       = errors.New("calculation is leased to another agent")
 )
 
+// HeartbeatInterval is how often agents should check in.
+// An agent that missed three heartbeats in a row is considered dead.
+var HeartbeatInterval = 5 * time.Second
+
+// ErrAgentNotFound is returned for heartbeats of agents we don't know about.
+var ErrAgentNotFound = errors.New("no such agent")
+
+// MaxTasksPerClaim caps how many calculations a single agent request can claim.
+const MaxTasksPerClaim = 100
+
+// MaxBatchSize caps how many expressions a single batch can have.
+const MaxBatchSize = 10000
+
+// MaxBatchBytes caps the body of a batch, so it can't make us read it all into memory
+// before we even know how many expressions there are.
+const MaxBatchBytes = 16 << 20
+
+// MaxIdempotencyKeyLength caps the Idempotency-Key header of new expressions.
+const MaxIdempotencyKeyLength = 255
+
+// ResultCache remembers results of calculations for everybody, so a calculation
+// that any user has already had done isn't sent to the agents again.
+// It keeps at most 10000 results, each for 10 minutes.
+var ResultCache = cache.New(10000, 10*time.Minute)
+
+var (
+	db                *sql.DB
+	tasksMutex        sync.Mutex
+	calculationsMutex sync.Mutex
+	Tasks             = make(map[int]service.Task)
+	Calculations      = []service.Calculation{}
+	BeingCalculated   = []service.Calculation{}
+	tasksReadyMutex   sync.Mutex
+	tasksReady        = make(chan struct{})
+)
+
+// SetDB gives the handlers the database to work with.
+// It is opened once, when the orchestrator starts.
+func SetDB(shared *sql.DB) {
+	db = shared
+}
+
+// TasksReady returns a channel that gets closed as soon as new calculations
+// are waiting in the queue. Grab it before looking into the queue,
+// otherwise a notification can slip in between.
+func TasksReady() <-chan struct{} {
+	tasksReadyMutex.Lock()
+	defer tasksReadyMutex.Unlock()
+	return tasksReady
+}
+
+func notifyTasksReady() {
+	tasksReadyMutex.Lock()
+	defer tasksReadyMutex.Unlock()
+	close(tasksReady)
+	tasksReady = make(chan struct{})
+}
+
 func TaskPage(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
@@ -69,24 +136,6 @@ func AddTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	NewTask := service.Task{
-		Owner: name,
-	}
-
-	// Opening a connection to the db and creating the tables if necessary.
-	db, err = sql.Open("sqlite3", "./data.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// This is important!
-	// Foreign keys might not always be on by default.
-	// However, we heavily rely on them, so if they don't work, we're toast.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
-	if err != nil {
-		log.Fatal("Failed to enable foreign key constraints:", err)
-	}
-
 	if r.Method == http.MethodPost && r.Header.Get("Content-Type") == "application/json" {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -94,96 +143,448 @@ func AddTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err = json.Unmarshal(body, &NewTask); err != nil {
+		var request service.TaskRequest
+		if err = json.Unmarshal(body, &request); err != nil {
 			log.Println(err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeRequestError(w, fmt.Errorf("not an expression: %v", err))
 			return
 		}
 
-		if calculate.IsFloat(NewTask.Expression) {
-			http.Error(w, "Bad Request", http.StatusUnprocessableEntity)
+		if hasClientId(body) {
+			writeRequestError(w, errors.New("id is assigned by the server"))
 			return
 		}
 
-		if err = calculate.ValidateInfixExpression(NewTask.Expression); err != nil {
-			log.Printf("%v\n", err)
-			http.Error(w, "Bad Request", http.StatusUnprocessableEntity)
+		var ownerID int
+		err = db.QueryRow(`SELECT id FROM users WHERE name = ?`, name).Scan(&ownerID)
+
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		var checkTask int
-
-		err = db.QueryRow("SELECT id FROM expressions WHERE id = ?", NewTask.Id).Scan(checkTask)
+		// A retry of a request that got through gets the expression made the first time,
+		// so a client that lost the answer can safely send the request again.
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if len(idempotencyKey) > MaxIdempotencyKeyLength {
+			writeRequestError(w, errors.New("Idempotency-Key is too long"))
+			return
+		}
+		bodyHash := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(bodyHash[:])
+		if idempotencyKey != "" && replayIdempotentRequest(w, ownerID, idempotencyKey, requestHash) {
+			return
+		}
 
-		if err == nil {
-			http.Error(w, "Conflict", http.StatusConflict)
+		NewTask, tree, err := prepareTask(request, name)
+		if err != nil {
+			log.Printf("%v\n", err)
+			writeRequestError(w, err)
 			return
 		}
 
-		NewTask.Status = "In Process"
-		NewTask.Original_Expression = NewTask.Expression
+		calculationsMutex.Lock()
+		defer calculationsMutex.Unlock()
 
-		var ownerID int
-		err = db.QueryRow(`SELECT id FROM users WHERE name = ?`, name).Scan(&ownerID)
+		// The same request could have been sent again while we were busy with this one.
+		if idempotencyKey != "" && replayIdempotentRequest(w, ownerID, idempotencyKey, requestHash) {
+			return
+		}
 
+		tx, err := db.Begin()
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		defer tx.Rollback()
 
-		_, err = db.Exec(`INSERT INTO expressions (id, status, original_expression, expression, result, owner) VALUES (?, ?, ?, ?, ?, ?)`, NewTask.Id, NewTask.Status, NewTask.Original_Expression, NewTask.Expression, NewTask.Result, ownerID)
-
+		scheduled, err := insertTask(tx, &NewTask, tree, ownerID, idempotencyKey, requestHash)
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		newRPN, err := calculate.InfixToRPN(NewTask.Expression)
-		log.Println(newRPN)
-
-		if err != nil {
-			http.Error(w, "Bad Request", http.StatusBadRequest)
+		if err = tx.Commit(); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		log.Printf("Expression %d scheduled %d calculation(s).\n", NewTask.Id, scheduled)
+		if scheduled > 0 {
+			notifyTasksReady()
+		}
 
-		w.WriteHeader(http.StatusAccepted)
-		fmt.Fprintf(w, "{}")
-
-		go func() {
-			calculationsMutex.Lock()
-			defer calculationsMutex.Unlock()
-			calculate.RPNtoSeparateCalculations(newRPN, NewTask.Id, db)
-		}()
+		writeTaskCreated(w, NewTask.Id, NewTask.Status)
 	} else {
-		http.Error(w, "Bad Request", http.StatusUnprocessableEntity)
+		writeRequestError(w, errors.New("expected a POST with a JSON expression"))
 		return
 	}
 }
 
-func HandleAllExpressions(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
+// ErrUnknownMode is a numeric mode we don't know how to calculate in.
+var ErrUnknownMode = errors.New("unknown numeric mode")
 
-	name, err := service.CheckAuthentication(r)
+// hasClientId tells if a submitted expression comes with its own id.
+// Ids are given out by the database, a client that picks its own would collide with somebody.
+func hasClientId(body []byte) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return false
+	}
+	_, ok := fields["id"]
+	return ok
+}
+
+// prepareTask checks a submitted expression and turns it into the task to be saved
+// and the tree to be calculated. The error is either a *calculate.ParseError or ErrUnknownMode.
+func prepareTask(request service.TaskRequest, owner string) (service.Task, *calculate.Node, error) {
+	task := service.Task{
+		Status:              "In Process",
+		Original_Expression: request.Expression,
+		Expression:          request.Expression,
+		Mode:                request.Mode,
+		Variables:           request.Variables,
+		Owner:               owner,
+	}
+	if task.Mode == "" {
+		task.Mode = calculate.ModeInt64
+	}
+	if !calculate.ValidMode(task.Mode) {
+		return task, nil, fmt.Errorf("%w %q", ErrUnknownMode, task.Mode)
+	}
+
+	// The expression is kept as a tree of nodes, so finishing a calculation
+	// only has to look at the nodes that were waiting for it.
+	variables := map[string]string{}
+	for name, value := range task.Variables {
+		variables[name] = value.String()
+	}
+	tree, err := calculate.Parse(task.Expression, variables)
+	if err != nil {
+		return task, nil, err
+	}
+
+	// E.g. a number too big for int64 mode.
+	if err = calculate.ValidateNumbers(tree, task.Mode); err != nil {
+		return task, nil, err
+	}
+
+	// E.g. x * 1 or 0 * (a huge subtree), the agents don't have to sleep on that.
+	if request.Optimize == nil || *request.Optimize {
+		parsed := calculate.Format(tree)
+		tree = calculate.Optimize(tree, task.Mode)
+		if optimized := calculate.Format(tree); optimized != parsed {
+			task.Optimized_Expression = optimized
+		}
+	}
+
+	// E.g. 42, (7), -(7) or x: there's nothing to calculate,
+	// so the expression is finished right away and no agent ever sees it.
+	if tree.IsNumber() {
+		// Written the way the mode writes its results, e.g. 0.50 is 1/2 in rational mode.
+		// The number is valid in the mode already, so this can't fail.
+		tree.Value, err = calculate.EvalRPN([]string{tree.Value}, task.Mode)
+		if err != nil {
+			return task, nil, err
+		}
+		task.Status = "Finished"
+		task.Result = tree.Value
+		task.Expression = tree.Value
+	}
+
+	return task, tree, nil
+}
+
+// insertTask saves a prepared expression and sends its first calculations to the agents.
+// It fills in the id of the task and returns how many calculations were scheduled.
+// The caller must hold calculationsMutex.
+func insertTask(tx *sql.Tx, task *service.Task, tree *calculate.Node, ownerID int, idempotencyKey, requestHash string) (int, error) {
+	// Kept only to show them back to the user, the tree already has the values in it.
+	variablesJSON, err := json.Marshal(task.Variables)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	res, err := tx.Exec(`INSERT INTO expressions (status, original_expression, expression, result, mode, variables, optimized_expression, idempotency_key, request_hash, owner, created_at, finished_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		task.Status, task.Original_Expression, task.Expression, task.Result, task.Mode, string(variablesJSON),
+		sql.NullString{String: task.Optimized_Expression, Valid: task.Optimized_Expression != ""},
+		sql.NullString{String: idempotencyKey, Valid: idempotencyKey != ""},
+		sql.NullString{String: requestHash, Valid: idempotencyKey != ""},
+		ownerID, now, sql.NullInt64{Int64: now, Valid: task.Status == "Finished"})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	task.Id = int(id)
+
+	if err = calculate.SaveTree(tx, task.Id, tree); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE expressions SET root_node = ? WHERE id = ?`, tree.Id, task.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Everything that can be calculated right away goes to the agents at once.
+	scheduled, err := calculate.ScheduleNodes(tx, task.Id, calculate.ReadyNodes(tree))
+	if err != nil {
+		return 0, err
+	}
+
+	// The results of all the calculations might have been in the cache.
+	if task.Status != "Finished" {
+		value, done, err := calculate.NodeValue(tx, tree.Id)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			task.Status = "Finished"
+			task.Result = value
+			task.Expression = value
+			_, err = tx.Exec(`UPDATE expressions SET status = ?, expression = ?, result = ?, finished_at = ? WHERE id = ?`, task.Status, task.Expression, task.Result, now, task.Id)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return scheduled, nil
+}
+
+// writeTaskCreated answers with the id of a new expression and where to find it,
+// so the client knows which expression to look at.
+func writeTaskCreated(w http.ResponseWriter, id int, status string) {
+	self := fmt.Sprintf("/api/v1/expressions/%d", id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", self)
+	w.WriteHeader(http.StatusAccepted)
+	err := json.NewEncoder(w).Encode(service.TaskStatus{
+		Id:     id,
+		Status: status,
+		Links:  map[string]string{"self": self},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// replayIdempotentRequest answers a request whose Idempotency-Key the user has already used:
+// with the expression made back then if the request is the same, or with 409 if it isn't.
+// It returns false if the key wasn't used yet and the request has to be handled as usual.
+func replayIdempotentRequest(w http.ResponseWriter, ownerID int, key, requestHash string) bool {
+	var id int
+	var status, storedHash string
+	err := db.QueryRow(`SELECT id, status, request_hash FROM expressions WHERE owner = ? AND idempotency_key = ?`, ownerID, key).Scan(&id, &status, &storedHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return true
+	}
+
+	if storedHash != requestHash {
+		http.Error(w, "Conflict: Idempotency-Key was already used for a different request", http.StatusConflict)
+		return true
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeTaskCreated(w, id, status)
+	return true
+}
+
+// writeRequestError answers 422 with what is wrong with the expression and where,
+// e.g. {"error":{"kind":"misplaced_operator","message":"operator * is misplaced","offset":4,"token":"*"}}.
+// Anything else wrong with the request is {"error":{"kind":"invalid_request","message":"..."}}.
+func writeRequestError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := json.NewEncoder(w).Encode(map[string]any{"error": describeRequestError(err)}); err != nil {
+		log.Println(err)
+	}
+}
+
+// describeRequestError is what the client is told about an error of its request:
+// the *calculate.ParseError itself, or a requestError.
+func describeRequestError(err error) any {
+	var parseError *calculate.ParseError
+	if errors.As(err, &parseError) {
+		return parseError
+	}
+	return requestError{ErrKindInvalidRequest, err.Error()}
+}
 
+// HandleBatch adds many expressions at once. The body is either a JSON array of expressions
+// or one expression per line (NDJSON), each one the same as for /api/v1/calculate.
+// Every expression is checked on its own, and all the good ones are saved in one transaction.
+func HandleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, err := service.CheckAuthentication(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Opening a connection to the db and creating the tables if necessary.
-	db, err = sql.Open("sqlite3", "./data.db")
+	var ownerID int
+	err = db.QueryRow(`SELECT id FROM users WHERE name = ?`, name).Scan(&ownerID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBatchBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request Entity Too Large: at most %d bytes per batch", MaxBatchBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	items, err := splitBatch(body)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if len(items) == 0 {
+		writeRequestError(w, errors.New("the batch is empty"))
+		return
+	}
+	if len(items) > MaxBatchSize {
+		http.Error(w, fmt.Sprintf("Request Entity Too Large: at most %d expressions per batch", MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	result := service.BatchResult{Items: make([]service.BatchItem, len(items))}
+	tasks := make([]service.Task, len(items))
+	trees := make([]*calculate.Node, len(items))
+	for i, item := range items {
+		result.Items[i].Index = i
+
+		var request service.TaskRequest
+		if err := json.Unmarshal(item, &request); err != nil {
+			result.Items[i].Error = requestError{ErrKindInvalidRequest, "not an expression: " + err.Error()}
+			continue
+		}
+		if hasClientId(item) {
+			result.Items[i].Error = requestError{ErrKindInvalidRequest, "id is assigned by the server"}
+			continue
+		}
+
+		tasks[i], trees[i], err = prepareTask(request, name)
+		if err != nil {
+			result.Items[i].Error = describeRequestError(err)
+		}
+	}
+
+	calculationsMutex.Lock()
+	defer calculationsMutex.Unlock()
+
+	tx, err := db.Begin()
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	scheduled := 0
+	for i := range items {
+		if result.Items[i].Error != nil {
+			result.Rejected++
+			continue
+		}
+
+		count, err := insertTask(tx, &tasks[i], trees[i], ownerID, "", "")
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		scheduled += count
+
+		result.Accepted++
+		result.Items[i].Id = tasks[i].Id
+		result.Items[i].Status = tasks[i].Status
+		result.Items[i].Links = map[string]string{"self": fmt.Sprintf("/api/v1/expressions/%d", tasks[i].Id)}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+	log.Printf("Batch of %d expression(s): %d accepted, %d scheduled calculation(s).\n", len(items), result.Accepted, scheduled)
+	if scheduled > 0 {
+		notifyTasksReady()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Accepted > 0 {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Println(err)
+	}
+}
+
+// ErrKindInvalidRequest is the kind of errors of requests that aren't about a place in the expression,
+// e.g. an unknown mode or an item of a batch that isn't even an expression.
+const ErrKindInvalidRequest = "invalid_request"
+
+// requestError is what is wrong with a request, when it isn't a *calculate.ParseError.
+type requestError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// splitBatch splits the body of a batch into its items:
+// the elements of a JSON array, or the lines of NDJSON without the empty ones.
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, fmt.Errorf("not a JSON array: %v", err)
+		}
+		return items, nil
+	}
+
+	items := []json.RawMessage{}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+func HandleAllExpressions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	name, err := service.CheckAuthentication(r)
 
-	// This is important!
-	// Foreign keys might not always be on by default.
-	// However, we heavily rely on them, so if they don't work, we're toast.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
 	if err != nil {
-		log.Fatal("Failed to enable foreign key constraints:", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
 	var userId int
@@ -196,31 +597,67 @@ func HandleAllExpressions(w http.ResponseWriter, r *http.Request) {
 
 	if id == "" {
 		if r.Method == http.MethodGet {
-			rows, err := db.Query(`SELECT id, status, original_expression, expression, result, owner FROM expressions WHERE owner = ?`, userId)
+			page, err := parseExpressionsPage(r.URL.Query())
 			if err != nil {
+				http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			query, args := page.query(userId)
+			rows, err := db.Query(query, args...)
+			if err != nil {
+				log.Println(err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 				return
 			}
+			defer rows.Close()
 			tasksMutex.Lock()
-			all_expressions := make([]service.Task, 0, len(Tasks))
+			all_expressions := make([]service.Task, 0, page.limit+1)
+			// The sort key of every expression, the cursor is made of them.
+			keys := make([]int64, 0, page.limit+1)
 			for rows.Next() {
-				var exp_id, owner, result int
-				var status, original_expression, expression string
-				err := rows.Scan(&exp_id, &status, &original_expression, &expression, &result, &owner)
+				var exp_id, owner int
+				var status, original_expression, expression, result, mode string
+				var variables, optimized_expression sql.NullString
+				var created_at, finished_at *int64
+				var key int64
+				err := rows.Scan(&exp_id, &status, &original_expression, &expression, &result, &mode, &variables, &optimized_expression, &created_at, &finished_at, &owner, &key)
 				if err != nil {
 					// We really shouldn't terminate the whole server if there is a faulty expression...
 					log.Printf("A very bad error while retrieving all expressions: %v", err)
+					continue
 				}
+				keys = append(keys, key)
 				all_expressions = append(all_expressions, service.Task{
-					Id:                  exp_id,
-					Status:              status,
-					Original_Expression: original_expression,
-					Expression:          expression,
-					Result:              result,
-					Owner:               name,
+					Id:                   exp_id,
+					Status:               status,
+					Original_Expression:  original_expression,
+					Expression:           expression,
+					Result:               result,
+					Mode:                 mode,
+					Variables:            decodeVariables(variables),
+					Optimized_Expression: optimized_expression.String,
+					Created_At:           formatTimestamp(created_at),
+					Finished_At:          formatTimestamp(finished_at),
+					Owner:                name,
 				})
 			}
 			tasksMutex.Unlock()
+			if err := rows.Err(); err != nil {
+				log.Println(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			// The extra expression only tells that there is a next page, which starts after the last one shown.
+			if len(all_expressions) > page.limit {
+				all_expressions = all_expressions[:page.limit]
+				cursor := page.cursor(keys[page.limit-1], all_expressions[page.limit-1].Id)
+				next := r.URL.Query()
+				next.Set("cursor", cursor)
+				w.Header().Set("Link", fmt.Sprintf(`</api/v1/expressions?%s>; rel="next"`, next.Encode()))
+				w.Header().Set("X-Next-Cursor", cursor)
+			}
 
 			expressions, err := json.Marshal(all_expressions)
 			if err != nil {
@@ -240,10 +677,17 @@ func HandleAllExpressions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var exp_id, owner, result int
-		var status, original_expression, expression string
+		if r.Method == http.MethodDelete {
+			cancelTask(w, searchedTaskId, userId)
+			return
+		}
 
-		err = db.QueryRow(`SELECT id, status, original_expression, expression, result, owner FROM expressions WHERE id = ?`, searchedTaskId).Scan(&exp_id, &status, &original_expression, &expression, &result, &owner)
+		var exp_id, owner int
+		var status, original_expression, expression, result, mode string
+		var variables, optimized_expression sql.NullString
+		var created_at, finished_at *int64
+
+		err = db.QueryRow(`SELECT id, status, original_expression, expression, result, mode, variables, optimized_expression, created_at, finished_at, owner FROM expressions WHERE id = ?`, searchedTaskId).Scan(&exp_id, &status, &original_expression, &expression, &result, &mode, &variables, &optimized_expression, &created_at, &finished_at, &owner)
 
 		if err != nil {
 			http.Error(w, "Not Found", http.StatusNotFound)
@@ -265,12 +709,17 @@ func HandleAllExpressions(w http.ResponseWriter, r *http.Request) {
 		}
 
 		searchedTaskJson, err := json.Marshal(service.Task{
-			Id:                  exp_id,
-			Status:              status,
-			Original_Expression: original_expression,
-			Expression:          expression,
-			Result:              result,
-			Owner:               name,
+			Id:                   exp_id,
+			Status:               status,
+			Original_Expression:  original_expression,
+			Expression:           expression,
+			Result:               result,
+			Mode:                 mode,
+			Variables:            decodeVariables(variables),
+			Optimized_Expression: optimized_expression.String,
+			Created_At:           formatTimestamp(created_at),
+			Finished_At:          formatTimestamp(finished_at),
+			Owner:                name,
 		})
 
 		if err != nil {
@@ -286,26 +735,100 @@ func HandleAllExpressions(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func HandleRegistration(w http.ResponseWriter, r *http.Request) {
-	// If it's not a POST request, we don't want it.
-	if r.Method != http.MethodPost {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+// cancelTask stops an expression that is still being calculated: its waiting calculations
+// are dropped, and the agents busy with the rest are told to give up on them with their next heartbeat.
+func cancelTask(w http.ResponseWriter, taskId, userId int) {
+	// Nobody may finish a calculation of the expression or schedule a new one meanwhile.
+	tasksMutex.Lock()
+	defer tasksMutex.Unlock()
+	calculationsMutex.Lock()
+	defer calculationsMutex.Unlock()
+
+	var owner int
+	var status string
+	err := db.QueryRow(`SELECT owner, status FROM expressions WHERE id = ?`, taskId).Scan(&owner, &status)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if owner != userId {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if status != "In Process" {
+		http.Error(w, "Conflict: expression is already "+status, http.StatusConflict)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	// Opening a connection to the db and creating the tables if necessary.
-	db, err := sql.Open("sqlite3", "./data.db")
+	_, err = tx.Exec(`UPDATE expressions SET status = 'Cancelled', finished_at = ? WHERE id = ?`, time.Now().Unix(), taskId)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := dropPendingCalculations(tx, taskId); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
-	defer db.Close()
+	log.Printf("Expression %d was cancelled.\n", taskId)
 
-	// This is important!
-	// Foreign keys might not always be on by default.
-	// However, we heavily rely on them, so if they don't work, we're toast.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(service.TaskStatus{
+		Id:     taskId,
+		Status: "Cancelled",
+		Links:  map[string]string{"self": fmt.Sprintf("/api/v1/expressions/%d", taskId)},
+	})
 	if err != nil {
-		log.Fatal("Failed to enable foreign key constraints:", err)
+		log.Println(err)
+	}
+}
+
+// dropPendingCalculations stops the calculations of an expression that nobody needs anymore:
+// the waiting ones are dropped, and the agents busy with the rest are told to give up on them with their next heartbeat.
+func dropPendingCalculations(tx *sql.Tx, taskId int) error {
+	for _, statement := range []string{
+		`DELETE FROM tasks WHERE task_id = ? AND status = 'Waiting'`,
+		// The agent keeps its id on them until it hears about it.
+		`UPDATE tasks SET status = 'Cancelled', lease_deadline = NULL WHERE task_id = ? AND status = 'In Process'`,
+	} {
+		if _, err := tx.Exec(statement, taskId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeVariables reads the variables of an expression back from the database.
+func decodeVariables(stored sql.NullString) map[string]json.Number {
+	var variables map[string]json.Number
+	if stored.Valid {
+		if err := json.Unmarshal([]byte(stored.String), &variables); err != nil {
+			log.Printf("Broken variables %q: %v\n", stored.String, err)
+		}
+	}
+	return variables
+}
+
+func HandleRegistration(w http.ResponseWriter, r *http.Request) {
+	// If it's not a POST request, we don't want it.
+	if r.Method != http.MethodPost {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
 	}
 
 	// New user instance for unmarshalling.
@@ -359,19 +882,6 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Opening a connection to the db and creating the tables if necessary.
-	db, err = sql.Open("sqlite3", "./data.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	// Enable foreign key constraints.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
-	if err != nil {
-		log.Fatal("Failed to enable foreign key constraints:", err)
-	}
-
 	user := service.User{}
 
 	body, err := io.ReadAll(r.Body)
@@ -428,127 +938,381 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func GiveTask() (service.Calculation, error) {
-	// Opening a connection to the db and creating the tables if necessary.
-	db, err := sql.Open("sqlite3", "./data.db")
-	if err != nil {
-		log.Fatal(err)
+// GiveTasks claims up to max of the oldest waiting calculations for the given agent.
+// The claim is a lease: if the agent doesn't report back before the
+// deadline, RequeueExpiredLeases puts the calculation back in the queue.
+// If nothing is waiting, sql.ErrNoRows is returned.
+func GiveTasks(agentId string, max int) ([]service.Calculation, error) {
+	if max < 1 {
+		max = 1
+	}
+	if max > MaxTasksPerClaim {
+		max = MaxTasksPerClaim
 	}
 
-	// This is important!
-	// Foreign keys might not always be on by default.
-	// However, we heavily rely on them, so if they don't work, we're toast.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
+	calculationsMutex.Lock()
+	defer calculationsMutex.Unlock()
+
+	// Selecting and claiming happen in a single statement,
+	// so two agents can never get the same calculation.
+	deadline := time.Now().Add(LeaseDuration).Unix()
+	rows, err := db.Query(`UPDATE tasks SET status = 'In Process', agent_id = ?, lease_deadline = ?
+		WHERE id IN (SELECT id FROM tasks WHERE status = 'Waiting' AND node_id IS NOT NULL ORDER BY id LIMIT ?)
+		RETURNING task_id, node_id, RPN_string, mode, status, result`, agentId, deadline, max)
 	if err != nil {
-		log.Fatal("Failed to enable foreign key constraints:", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-		// Retrieve a calculation from the database
-		calculationsMutex.Lock()
-		defer calculationsMutex.Unlock()
-
+	calculations := []service.Calculation{}
+	for rows.Next() {
 		var calculation service.Calculation
-		err = db.QueryRow("SELECT task_id, RPN_string, status, result FROM tasks WHERE status = 'Waiting' LIMIT 1").Scan(
-			&calculation.Task_id,
-			&calculation.RPN_string,
-			&calculation.Status,
-			&calculation.Result,
-		)
-
+		err := rows.Scan(&calculation.Task_id, &calculation.Node_id, &calculation.RPN_string, &calculation.Mode, &calculation.Status, &calculation.Result)
 		if err != nil {
-			return service.Calculation{}, err
+			return nil, err
 		}
+		log.Printf("Calculation %s of task %d leased to agent %s.\n", calculation.RPN_string, calculation.Task_id, agentId)
+		calculations = append(calculations, calculation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(calculations) == 0 {
+		return nil, sql.ErrNoRows
+	}
 
-		return calculation, nil
+	return calculations, nil
 }
 
-func TakeTask(finishedCalculation service.Calculation) error {
+// RequeueExpiredLeases puts calculations whose lease has run out back to 'Waiting',
+// so a crashed agent can't leave an expression unfinished forever.
+func RequeueExpiredLeases() (int64, error) {
+	calculationsMutex.Lock()
+	defer calculationsMutex.Unlock()
+
+	res, err := db.Exec(`UPDATE tasks SET status = 'Waiting', agent_id = NULL, lease_deadline = NULL
+		WHERE status = 'In Process' AND lease_deadline IS NOT NULL AND lease_deadline < ?`, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// RunLeaseReaper checks for expired leases every interval. It never returns.
+func RunLeaseReaper(interval time.Duration) {
+	for range time.Tick(interval) {
+		requeued, err := RequeueExpiredLeases()
+		if err != nil {
+			log.Printf("Failed to requeue expired leases: %v\n", err)
+			continue
+		}
+		if requeued > 0 {
+			log.Printf("Requeued %d calculation(s) with expired leases.\n", requeued)
+			notifyTasksReady()
+		}
+	}
+}
+
+// TakeTask puts in the result an agent has reported.
+// The calculation and its expression change together in one transaction,
+// so a failure in between can't leave a reported calculation whose node was never completed.
+func TakeTask(agentId string, finishedCalculation service.Calculation) error {
 		if finishedCalculation.Status != "Finished" && finishedCalculation.Status != "Error" {
-			return errors.New("bad request")
+			return ErrBadCalculationStatus
 		}
 
-		// Update the calculation status in the database
-		beingCalculatedMutex.Lock()
-		_, err := db.Exec("UPDATE tasks SET status = ?, result = ? WHERE task_id = ? AND RPN_string = ?",
-			finishedCalculation.Status, finishedCalculation.Result, finishedCalculation.Task_id, finishedCalculation.RPN_string)
-		beingCalculatedMutex.Unlock()
+		// Several results of the same expression can come in at once,
+		// so nobody else may touch it until we've put ours in.
+		tasksMutex.Lock()
+		defer tasksMutex.Unlock()
+		calculationsMutex.Lock()
+		defer calculationsMutex.Unlock()
+
+		tx, err := db.Begin()
 		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Update the calculation status in the database.
+		// A late result for a calculation whose lease expired is still fine
+		// as long as nobody else has taken it or finished it in the meantime.
+		// What was actually calculated is taken from the database, not from the agent, since it goes into the cache.
+		var rpnString, mode string
+		err = tx.QueryRow("UPDATE tasks SET status = ?, result = ?, agent_id = NULL, lease_deadline = NULL WHERE task_id = ? AND node_id = ? AND (status = 'Waiting' OR (status = 'In Process' AND (agent_id = ? OR agent_id IS NULL))) RETURNING RPN_string, mode",
+			finishedCalculation.Status, finishedCalculation.Result, finishedCalculation.Task_id, finishedCalculation.Node_id, agentId).Scan(&rpnString, &mode)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			log.Printf("Failed to update calculation status: %v\n", err)
 			return err
 		}
+		if errors.Is(err, sql.ErrNoRows) {
+			var count, leasedToOthers int
+			err = tx.QueryRow("SELECT COUNT(*), COUNT(CASE WHEN status = 'In Process' THEN 1 END) FROM tasks WHERE task_id = ? AND node_id = ?", finishedCalculation.Task_id, finishedCalculation.Node_id).Scan(&count, &leasedToOthers)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				return ErrCalculationNotFound
+			}
+			var expressionStatus string
+			err = tx.QueryRow("SELECT status FROM expressions WHERE id = ?", finishedCalculation.Task_id).Scan(&expressionStatus)
+			if err == nil && expressionStatus == "Cancelled" {
+				log.Printf("Calculation %s of task %d came after the task was cancelled, ignoring.\n", finishedCalculation.RPN_string, finishedCalculation.Task_id)
+				return ErrTaskCancelled
+			}
+			if leasedToOthers > 0 {
+				log.Printf("Calculation %s of task %d came from agent %s after its lease was given to another agent, ignoring.\n", finishedCalculation.RPN_string, finishedCalculation.Task_id, agentId)
+				return ErrNotLeaseHolder
+			}
+			log.Printf("Calculation %s of task %d was already reported, ignoring.\n", finishedCalculation.RPN_string, finishedCalculation.Task_id)
+			return ErrAlreadyReported
+		}
 
 		// Retrieve the linked task
 		var linkedTask service.Task
-		tasksMutex.Lock()
-		err = db.QueryRow("SELECT id, status, original_expression, expression, result, owner FROM expressions WHERE id = ?", finishedCalculation.Task_id).Scan(
+		var rootNode int64
+		err = tx.QueryRow("SELECT id, status, original_expression, expression, root_node, result, owner FROM expressions WHERE id = ?", finishedCalculation.Task_id).Scan(
 			&linkedTask.Id,
 			&linkedTask.Status,
 			&linkedTask.Original_Expression,
 			&linkedTask.Expression,
+			&rootNode,
 			&linkedTask.Result,
 			&linkedTask.Owner,
 		)
-		tasksMutex.Unlock()
 		if err != nil {
 			log.Printf("Failed to retrieve linked task: %v\n", err)
 			return err
 		}
 
-		// Check if the task has already finished
-		if linkedTask.Status == "Finished" {
+		// Check if the task has already finished.
+		// The calculation is still reported, or it would go back to the queue and be handed out forever.
+		if linkedTask.Status == "Cancelled" {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			return ErrTaskCancelled
+		}
+		if linkedTask.Status != "In Process" {
 			log.Printf("Task already finished error")
-			return err
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			return ErrTaskFinished
 		}
 
 		if finishedCalculation.Status == "Error" {
-			linkedTask.Result = 0
+			linkedTask.Result = ""
 			linkedTask.Status = "Calculation Error"
-			tasksMutex.Lock()
-			_, err := db.Exec("UPDATE expressions SET status = ?, result = ? WHERE id = ?", linkedTask.Status, linkedTask.Result, linkedTask.Id)
-			tasksMutex.Unlock()
+			_, err := tx.Exec("UPDATE expressions SET status = ?, result = ?, finished_at = ? WHERE id = ?", linkedTask.Status, linkedTask.Result, time.Now().Unix(), linkedTask.Id)
 			if err != nil {
 				log.Printf("Failed to update task status to error: %v\n", err)
+				return err
 			}
-			return err
+			// The rest of the expression can't make it any better.
+			if err := dropPendingCalculations(tx, linkedTask.Id); err != nil {
+				log.Printf("Failed to drop the calculations of task %d: %v\n", linkedTask.Id, err)
+				return err
+			}
+			return tx.Commit()
 		}
 
-		log.Printf("Finished calculation: %s, Result: %d\n", finishedCalculation.RPN_string, finishedCalculation.Result)
-		log.Printf("Linked Task Expression infix: %s\n", linkedTask.Expression)
-		linkedExpressionRPN, _ := calculate.InfixToRPN(linkedTask.Expression)
-		log.Printf("Linked Task Expression RPN: %s\n", linkedExpressionRPN)
-		linkedExpressionRPN = strings.ReplaceAll(linkedExpressionRPN, finishedCalculation.RPN_string, fmt.Sprintf("%d", finishedCalculation.Result))
-		log.Printf("Linked Task Expression New RPN: %s\n", linkedExpressionRPN)
-		linkedExpressionInfix, _ := calculate.RPNtoInfix(linkedExpressionRPN)
-		log.Printf("Linked Task Expression New Infix: %s\n", linkedExpressionInfix)
-		linkedTask.Expression = linkedExpressionInfix
+		log.Printf("Finished calculation: %s (node %d), Result: %s\n", finishedCalculation.RPN_string, finishedCalculation.Node_id, finishedCalculation.Result)
 
-		tasksMutex.Lock()
-		_, err = db.Exec("UPDATE expressions SET expression = ? WHERE id = ?", linkedTask.Expression, linkedTask.Id)
-		tasksMutex.Unlock()
+		// Only the nodes waiting for this one are looked at, the rest of the expression stays as it is.
+		scheduled, err := calculate.CompleteNode(tx, int64(finishedCalculation.Node_id), finishedCalculation.Result)
 		if err != nil {
-			log.Printf("Failed to update linked task expression: %v\n", err)
+			log.Printf("Failed to complete node %d: %v\n", finishedCalculation.Node_id, err)
 			return err
 		}
 
-		if calculate.IsFloat(linkedTask.Expression) {
-			res, _ := strconv.ParseFloat(linkedTask.Expression, 64)
+		// Not only this node may have been completed, the cache could have done the rest.
+		value, done, err := calculate.NodeValue(tx, rootNode)
+		if err != nil {
+			log.Printf("Failed to look at the root of task %d: %v\n", linkedTask.Id, err)
+			return err
+		}
+		if done {
 			linkedTask.Status = "Finished"
-			linkedTask.Result = int(res)
-			tasksMutex.Lock()
-			_, err := db.Exec("UPDATE expressions SET status = ?, result = ? WHERE id = ?", linkedTask.Status, linkedTask.Result, linkedTask.Id)
-			tasksMutex.Unlock()
+			linkedTask.Result = value
+			linkedTask.Expression = value
+			_, err := tx.Exec("UPDATE expressions SET status = ?, expression = ?, result = ?, finished_at = ? WHERE id = ?", linkedTask.Status, linkedTask.Expression, linkedTask.Result, time.Now().Unix(), linkedTask.Id)
 			if err != nil {
 				log.Printf("Failed to mark task as finished: %v\n", err)
 				return err
 			}
-			log.Printf("FINISHED CALCULATING RESULT IS %d\n", linkedTask.Result)
-		} else {
-			go func() {
-				calculationsMutex.Lock()
-				defer calculationsMutex.Unlock()
-				calculate.RPNtoSeparateCalculations(linkedExpressionRPN, linkedTask.Id, db)
-			}()
+			log.Printf("FINISHED CALCULATING RESULT IS %s\n", linkedTask.Result)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		// Only results that made it into the database are worth sharing.
+		calculate.RememberResult(rpnString, mode, finishedCalculation.Result)
+
+		if scheduled > 0 {
+			notifyTasksReady()
 		}
 
 		return nil
-}
\ No newline at end of file
+}
+
+// RegisterAgent adds an agent to the registry, or refreshes it if it is already there.
+// If agentId is empty, a new id is made up for it.
+func RegisterAgent(agentId, hostname string, computingPower int) (string, error) {
+	if agentId == "" {
+		idBytes := make([]byte, 8)
+		if _, err := rand.Read(idBytes); err != nil {
+			return "", err
+		}
+		agentId = hex.EncodeToString(idBytes)
+	}
+
+	now := time.Now().Unix()
+	_, err := db.Exec(`INSERT INTO agents (id, hostname, computing_power, free_slots, registered_at, last_seen) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET hostname = excluded.hostname, computing_power = excluded.computing_power, last_seen = excluded.last_seen`,
+		agentId, hostname, computingPower, computingPower, now, now)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Agent %s (%s) registered with computing power %d.\n", agentId, hostname, computingPower)
+	return agentId, nil
+}
+
+// AgentHeartbeat marks the agent as alive and extends the leases
+// of the calculations it says it is working on. The rest of its calculations
+// were lost on the way or dropped by the agent, so they go back to the queue.
+func AgentHeartbeat(agentId string, freeSlots int, inFlight []service.Calculation) ([]int64, error) {
+	res, err := db.Exec(`UPDATE agents SET free_slots = ?, last_seen = ? WHERE id = ?`, freeSlots, time.Now().Unix(), agentId)
+	if err != nil {
+		return nil, err
+	}
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		return nil, ErrAgentNotFound
+	}
+
+	calculationsMutex.Lock()
+	defer calculationsMutex.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deadline := time.Now().Add(LeaseDuration).Unix()
+	for _, calculation := range inFlight {
+		_, err = tx.Exec(`UPDATE tasks SET lease_deadline = ? WHERE agent_id = ? AND status = 'In Process' AND task_id = ? AND node_id = ?`,
+			deadline, agentId, calculation.Task_id, calculation.Node_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// What was leased less than a heartbeat ago may still be on its way to the agent,
+	// so only the leases that weren't extended just now and are older than that are taken back.
+	res, err = tx.Exec(`UPDATE tasks SET status = 'Waiting', agent_id = NULL, lease_deadline = NULL
+		WHERE agent_id = ? AND status = 'In Process' AND lease_deadline <= ?`,
+		agentId, time.Now().Add(LeaseDuration-HeartbeatInterval).Unix())
+	if err != nil {
+		return nil, err
+	}
+	requeued, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if requeued > 0 {
+		log.Printf("Agent %s isn't working on %d of its calculation(s) anymore, requeued them.\n", agentId, requeued)
+		notifyTasksReady()
+	}
+
+	// Calculations of cancelled expressions the agent is still sleeping on.
+	// Each of them is told about once, then it's not the agent's anymore.
+	rows, err := db.Query(`UPDATE tasks SET agent_id = NULL WHERE agent_id = ? AND status = 'Cancelled' RETURNING task_id`, agentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cancelled := []int64{}
+	for rows.Next() {
+		var taskId int64
+		if err := rows.Scan(&taskId); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(cancelled, taskId) {
+			cancelled = append(cancelled, taskId)
+		}
+	}
+	return cancelled, rows.Err()
+}
+
+// HandleAgents lists every agent the orchestrator knows about.
+func HandleAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, err := service.CheckAuthentication(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.Query(`SELECT a.id, a.hostname, a.computing_power, a.free_slots, a.last_seen,
+		(SELECT COUNT(*) FROM tasks t WHERE t.agent_id = a.id AND t.status = 'In Process')
+		FROM agents a ORDER BY a.last_seen DESC`)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	aliveSince := time.Now().Add(-3 * HeartbeatInterval)
+	agents := []service.Agent{}
+	for rows.Next() {
+		var agent service.Agent
+		var lastSeen int64
+		err := rows.Scan(&agent.Id, &agent.Hostname, &agent.ComputingPower, &agent.FreeSlots, &lastSeen, &agent.InFlight)
+		if err != nil {
+			log.Printf("Failed to read an agent: %v\n", err)
+			continue
+		}
+		agent.LastSeen = time.Unix(lastSeen, 0).Format(time.RFC3339)
+		agent.Alive = time.Unix(lastSeen, 0).After(aliveSince)
+		agents = append(agents, agent)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(agents); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// HandleResultCache shows how often calculations are taken from ResultCache instead of the agents.
+func HandleResultCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, err := service.CheckAuthentication(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ResultCache.Stats()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
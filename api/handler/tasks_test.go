@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"database/sql"
+	"distributed-calculator/internal/service"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// newTestDB gives the handlers an empty database with the tables of the orchestrator.
+func newTestDB(t *testing.T) {
+	t.Helper()
+	shared, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared.SetMaxOpenConns(1)
+	t.Cleanup(func() { shared.Close() })
+
+	_, err = shared.Exec(`
+	CREATE TABLE users (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"name" TEXT UNIQUE,
+		"password" TEXT
+	);
+	CREATE TABLE expressions (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"status" TEXT NOT NULL,
+		"original_expression" TEXT NOT NULL,
+		"expression" TEXT NOT NULL,
+		"result" TEXT,
+		"mode" TEXT NOT NULL DEFAULT 'int64',
+		"variables" TEXT,
+		"optimized_expression" TEXT,
+		"idempotency_key" TEXT,
+		"request_hash" TEXT,
+		"created_at" INTEGER,
+		"finished_at" INTEGER,
+		"owner" INTEGER,
+		"root_node" INTEGER
+	);
+	CREATE TABLE tasks (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"RPN_string" TEXT,
+		"mode" TEXT NOT NULL DEFAULT 'int64',
+		"status" TEXT,
+		"Result" TEXT,
+		"task_id" INTEGER,
+		"node_id" INTEGER,
+		"agent_id" TEXT,
+		"lease_deadline" INTEGER
+	);
+	CREATE TABLE nodes (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"expression_id" INTEGER NOT NULL,
+		"op" TEXT,
+		"value" TEXT,
+		"state" TEXT NOT NULL
+	);
+	CREATE TABLE node_operands (
+		"node_id" INTEGER NOT NULL,
+		"operand_id" INTEGER NOT NULL,
+		"position" INTEGER NOT NULL,
+		PRIMARY KEY(node_id, position)
+	);
+	CREATE TABLE agents (
+		"id" TEXT NOT NULL PRIMARY KEY,
+		"hostname" TEXT,
+		"computing_power" INTEGER,
+		"free_slots" INTEGER,
+		"registered_at" INTEGER,
+		"last_seen" INTEGER
+	);
+	INSERT INTO users (name, password) VALUES ('u', 'p');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDB(shared)
+}
+
+// submit saves the expression and leases all its first calculations to the agent.
+func submit(t *testing.T, expression, agentId string) (int, map[string]service.Calculation) {
+	t.Helper()
+	task, tree, err := prepareTask(service.TaskRequest{Expression: expression}, "u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := insertTask(tx, &task, tree, 1, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RegisterAgent(agentId, "test", 4); err != nil {
+		t.Fatal(err)
+	}
+	calculations, err := GiveTasks(agentId, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leased := map[string]service.Calculation{}
+	for _, calculation := range calculations {
+		leased[calculation.RPN_string] = calculation
+	}
+	return task.Id, leased
+}
+
+func report(agentId string, calculation service.Calculation, status, result string) error {
+	calculation.Status, calculation.Result = status, result
+	return TakeTask(agentId, calculation)
+}
+
+// Once a calculation fails, the rest of the expression isn't handed out anymore,
+// and the agents busy with it are told to drop it.
+func TestCalculationErrorDropsTheRest(t *testing.T) {
+	newTestDB(t)
+	id, leased := submit(t, "(1 / 0) + (2 * 3) + (4 - 5)", "a")
+	if len(leased) != 3 {
+		t.Fatalf("got %v leased", leased)
+	}
+	if _, err := db.Exec(`UPDATE tasks SET status = 'Waiting', agent_id = NULL WHERE RPN_string = '4 5 -'`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := report("a", leased["1 0 /"], "Error", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GiveTasks("b", 4); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v, the calculations of a failed expression were handed out", err)
+	}
+
+	cancelled, err := AgentHeartbeat("a", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(cancelled, int64(id)) {
+		t.Errorf("agent a wasn't told to drop the calculations of %d: %v", id, cancelled)
+	}
+	if err := report("a", leased["2 3 *"], "Finished", "6"); err == nil {
+		t.Error("a result of a failed expression was accepted")
+	}
+}
+
+// A result for an expression that is already finished is turned down,
+// but the calculation must not go back to the queue because of it.
+func TestLateResultIsNotHandedOutAgain(t *testing.T) {
+	newTestDB(t)
+	id, leased := submit(t, "(1 / 0) + (2 * 3)", "a")
+	// E.g. it failed before the rest of the calculations were dropped on errors.
+	if _, err := db.Exec(`UPDATE expressions SET status = 'Calculation Error' WHERE id = ?`, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := report("a", leased["2 3 *"], "Finished", "6"); !errors.Is(err, ErrTaskFinished) {
+		t.Fatalf("got %v, want %v", err, ErrTaskFinished)
+	}
+
+	var status string
+	if err := db.QueryRow(`SELECT status FROM tasks WHERE RPN_string = '2 3 *'`).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status == "In Process" || status == "Waiting" {
+		t.Errorf("the calculation is %s, it will be handed out again", status)
+	}
+	if _, err := AgentHeartbeat("a", 4, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calculations, err := GiveTasks("b", 4); err == nil && slices.ContainsFunc(calculations, func(c service.Calculation) bool { return c.RPN_string == "2 3 *" }) {
+		t.Error("the calculation was handed out again")
+	}
+}
+
+// The simplified expression is only shown when there was something to simplify.
+func TestOptimizedExpression(t *testing.T) {
+	for expression, want := range map[string]string{
+		"2 + 3":         "",
+		"(2 + 3)":       "",
+		"2 * 1 + 3 * 4": "2 + 3 * 4",
+	} {
+		task, _, err := prepareTask(service.TaskRequest{Expression: expression}, "u")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Optimized_Expression != want {
+			t.Errorf("%s was simplified to %q, want %q", expression, task.Optimized_Expression, want)
+		}
+	}
+}
@@ -8,18 +8,21 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"distributed-calculator/config"
 	calculate "distributed-calculator/internal/logic"
 	"distributed-calculator/internal/service"
 	pb "distributed-calculator/proto"
-	_ "github.com/mattn/go-sqlite3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"log"
+	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,35 +32,233 @@ var TIME_ADDITION_MS int = 5000
 var TIME_SUBTRACTION_MS int = 5000
 var TIME_MULTIPLICATIONS_MS int = 15000
 var TIME_DIVISIONS_MS int = 15000
-var Calculations = make(chan service.Calculation)
+var TIME_POWER_MS int = 20000
+var TIME_MODULO_MS int = 15000
+
+// How long each function takes, e.g. TIME_FUNCTION_MS["sqrt"],
+// and how long the ones missing from the config take.
+var TIME_FUNCTION_MS = map[string]int{}
+var TIME_FUNCTION_DEFAULT_MS int = 10000
+var ORCHESTRATOR_ADDRESS string = "localhost:50051"
+
+// How long to wait between polls at most when there is nothing to do,
+// and how many times to try sending a result before giving up.
+const MAX_POLL_BACKOFF = 16 * time.Second
+const MAX_SEND_ATTEMPTS = 5
+
+var Calculations chan service.Calculation
 var grpcClient pb.CalculatorServiceClient
 
+// AgentId identifies this agent in the orchestrator's registry and leases.
+// The orchestrator gives it to us when we register.
+var AgentId string
+
+// Busy counts the workers that are calculating something right now.
+var Busy atomic.Int64
+
+// Slots counts the workers that can start a calculation right away.
+var Slots *workerSlots
+
+// workerSlots keeps track of free workers, so we never claim
+// more calculations than we can start immediately.
+// A worker is taken out of the pool when we ask the orchestrator for work
+// on its behalf, and goes back when it's done (or when no work came).
+type workerSlots struct {
+	mu   sync.Mutex
+	free int
+	// changed gets a value whenever some workers are released.
+	changed chan struct{}
+}
+
+// Cancelled tells workers that the expression they are calculating a part of was cancelled.
+var Cancelled = newCancellations()
+
+// cancellations keeps a channel for every expression the workers are busy with,
+// which gets closed when the orchestrator says the expression was cancelled.
+type cancellations struct {
+	mu      sync.Mutex
+	signals map[int]*cancelSignal
+}
+
+type cancelSignal struct {
+	done    chan struct{}
+	closed  bool
+	workers int
+}
+
+func newCancellations() *cancellations {
+	return &cancellations{signals: map[int]*cancelSignal{}}
+}
+
+// watch returns a channel that is closed if the expression gets cancelled.
+// Every watch must be followed by a forget.
+func (c *cancellations) watch(taskId int) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	signal, ok := c.signals[taskId]
+	if !ok {
+		signal = &cancelSignal{done: make(chan struct{})}
+		c.signals[taskId] = signal
+	}
+	signal.workers++
+	return signal.done
+}
+
+func (c *cancellations) forget(taskId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	signal, ok := c.signals[taskId]
+	if !ok {
+		return
+	}
+	signal.workers--
+	if signal.workers <= 0 {
+		delete(c.signals, taskId)
+	}
+}
+
+// cancel stops the workers busy with the expressions.
+func (c *cancellations) cancel(taskIds []int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, taskId := range taskIds {
+		signal, ok := c.signals[int(taskId)]
+		if ok && !signal.closed {
+			close(signal.done)
+			signal.closed = true
+		}
+	}
+}
+
+// InFlight are the calculations we hold leases on, from the moment they arrive
+// until their result is sent or they are dropped. Heartbeats only keep these leases,
+// so whatever we've lost track of goes back to the orchestrator's queue.
+var InFlight = newInFlightCalculations()
+
+type inFlightCalculations struct {
+	mu sync.Mutex
+	// The same calculation may come again after its lease ran out,
+	// so every one is counted.
+	calculations map[inFlightKey]int
+}
+
+type inFlightKey struct {
+	taskId int
+	nodeId int
+}
+
+func newInFlightCalculations() *inFlightCalculations {
+	return &inFlightCalculations{calculations: map[inFlightKey]int{}}
+}
+
+func (f *inFlightCalculations) add(calc service.Calculation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calculations[inFlightKey{calc.Task_id, calc.Node_id}]++
+}
+
+func (f *inFlightCalculations) remove(calc service.Calculation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := inFlightKey{calc.Task_id, calc.Node_id}
+	f.calculations[key]--
+	if f.calculations[key] <= 0 {
+		delete(f.calculations, key)
+	}
+}
+
+// list returns the calculations the way heartbeats carry them.
+func (f *inFlightCalculations) list() []*pb.LeasedCalculation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := make([]*pb.LeasedCalculation, 0, len(f.calculations))
+	for key := range f.calculations {
+		list = append(list, &pb.LeasedCalculation{TaskId: int64(key.taskId), NodeId: int64(key.nodeId)})
+	}
+	return list
+}
+
+func newWorkerSlots(n int) *workerSlots {
+	return &workerSlots{free: n, changed: make(chan struct{}, 1)}
+}
+
+// takeAll reserves every free worker and returns how many there were.
+func (s *workerSlots) takeAll() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.free
+	s.free = 0
+	return n
+}
+
+// release puts n reserved workers back into the pool.
+func (s *workerSlots) release(n int) {
+	if n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.free += n
+	s.mu.Unlock()
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
 func Calculate() {
 	for {
 		select {
 		case calc := <-Calculations:
+			Busy.Add(1)
 			var sleepDuration time.Duration
 			RPNSlice := strings.Split(calc.RPN_string, " ")
+			functionName, _, isFunction := calculate.ParseFunctionToken(RPNSlice[len(RPNSlice)-1])
 			switch {
+			case isFunction:
+				ms, ok := TIME_FUNCTION_MS[functionName]
+				if !ok {
+					ms = TIME_FUNCTION_DEFAULT_MS
+				}
+				sleepDuration = time.Duration(ms) * time.Millisecond
 			case slices.Contains(RPNSlice, "+"):
 				sleepDuration = time.Duration(TIME_ADDITION_MS) * time.Millisecond
-			case slices.Contains(RPNSlice, "-"):
+			case slices.Contains(RPNSlice, "-"), slices.Contains(RPNSlice, calculate.OpNegate):
+				// Negation is subtraction from zero.
 				sleepDuration = time.Duration(TIME_SUBTRACTION_MS) * time.Millisecond
 			case slices.Contains(RPNSlice, "*"):
 				sleepDuration = time.Duration(TIME_MULTIPLICATIONS_MS) * time.Millisecond
 			case slices.Contains(RPNSlice, "/"):
 				sleepDuration = time.Duration(TIME_DIVISIONS_MS) * time.Millisecond
+			case slices.Contains(RPNSlice, "^"):
+				sleepDuration = time.Duration(TIME_POWER_MS) * time.Millisecond
+			case slices.Contains(RPNSlice, "%"):
+				sleepDuration = time.Duration(TIME_MODULO_MS) * time.Millisecond
 			default:
 				log.Println("Unsupported operation encountered.")
+				InFlight.remove(calc)
+				Busy.Add(-1)
+				Slots.release(1)
 				continue
 			}
 
-			time.Sleep(sleepDuration)
+			cancelled := Cancelled.watch(calc.Task_id)
+			select {
+			case <-time.After(sleepDuration):
+				Cancelled.forget(calc.Task_id)
+			case <-cancelled:
+				Cancelled.forget(calc.Task_id)
+				log.Printf("Expression %d was cancelled, dropping %s.\n", calc.Task_id, calc.RPN_string)
+				InFlight.remove(calc)
+				Busy.Add(-1)
+				Slots.release(1)
+				continue
+			}
 			log.Println(calc.RPN_string)
 			tokens := strings.Split(calc.RPN_string, " ")
-			result, err := calculate.EvalRPN(tokens)
+			result, err := calculate.EvalRPN(tokens, calc.Mode)
 			if err != nil {
-				log.Println("Division by zero encountered.")
+				log.Printf("Couldn't calculate %s: %v\n", calc.RPN_string, err)
 				calc.Status = "Error"
 				calc.Result = result
 			} else {
@@ -66,19 +267,209 @@ func Calculate() {
 				log.Printf("Finished calculation %d. Took %d ms.\n", calc.Task_id, sleepDuration.Milliseconds())
 			}
 
-			_, err = grpcClient.SendCalculation(context.TODO(), &pb.SendCalculationRequest{
-				TaskId:    int64(calc.Task_id),
-				RPNString: calc.RPN_string,
-				Status:    calc.Status,
-				Result:    int64(calc.Result),
-			})
+			sendResult(calc)
+			InFlight.remove(calc)
+			Busy.Add(-1)
+			Slots.release(1)
+		}
+	}
+}
+
+// sendResult reports a finished calculation to the orchestrator.
+// If the orchestrator is unreachable for a moment, we try again,
+// everything else means the result isn't needed anymore.
+func sendResult(calc service.Calculation) {
+	for attempt := 1; ; attempt++ {
+		_, err := grpcClient.SendCalculation(context.TODO(), &pb.SendCalculationRequest{
+			TaskId:    int64(calc.Task_id),
+			NodeId:    int64(calc.Node_id),
+			RPNString: calc.RPN_string,
+			Status:    calc.Status,
+			Result:    calc.Result,
+			AgentId:   AgentId,
+		})
 
-			if err != nil {
-				log.Printf("gRPC send error: %v", err)
+		switch status.Code(err) {
+		case codes.OK:
+			return
+		case codes.Unavailable:
+			if attempt < MAX_SEND_ATTEMPTS {
+				log.Printf("Orchestrator unavailable, retrying to send %s: %v", calc.RPN_string, err)
+				time.Sleep(time.Duration(attempt) * time.Second)
+				continue
 			}
+			log.Printf("Giving up on sending %s: %v", calc.RPN_string, err)
+		case codes.FailedPrecondition, codes.NotFound:
+			log.Printf("Result of %s is not needed anymore: %v", calc.RPN_string, status.Convert(err).Message())
+		case codes.InvalidArgument:
+			log.Printf("Orchestrator rejected the result of %s: %v", calc.RPN_string, status.Convert(err).Message())
+		default:
+			log.Printf("gRPC send error: %v", err)
 		}
+		return
 	}
 }
+
+// runWorkSession keeps a stream open to the orchestrator, which pushes calculations
+// to us as soon as they are ready, as long as we have free workers.
+func runWorkSession() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := grpcClient.WorkSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Workers we've promised to the orchestrator, but haven't got any work for yet.
+	// They go back to the pool when the session ends.
+	var promised atomic.Int64
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		Slots.release(int(promised.Load()))
+	}()
+
+	free := Slots.takeAll()
+	promised.Add(int64(free))
+	err = stream.Send(&pb.WorkSessionRequest{AgentId: AgentId, FreeSlots: int64(free)})
+	if err != nil {
+		return err
+	}
+	log.Printf("Work session started with %d free worker(s).\n", free)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-Slots.changed:
+				free := Slots.takeAll()
+				if free == 0 {
+					continue
+				}
+				promised.Add(int64(free))
+				if err := stream.Send(&pb.WorkSessionRequest{AgentId: AgentId, FreeSlots: int64(free)}); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		// The orchestrator never sends more than we asked for,
+		// so there's always a free worker waiting for it.
+		promised.Add(-1)
+		calc := calculationFromProto(resp.Calculation)
+		InFlight.add(calc)
+		Calculations <- calc
+	}
+}
+
+// pollForCalculations is the old way of getting work, for orchestrators
+// that don't know about work sessions. It never returns.
+func pollForCalculations() {
+	backoff := time.Second
+	for {
+		free := Slots.takeAll()
+		if free == 0 {
+			<-Slots.changed
+			continue
+		}
+
+		response, err := grpcClient.GetCalculation(context.TODO(), &pb.GetCalculationRequest{
+			AgentId:  AgentId,
+			MaxTasks: int64(free),
+		})
+		if err != nil {
+			Slots.release(free)
+			switch status.Code(err) {
+			case codes.NotFound:
+				// The queue is empty, no need to ask again every second.
+				backoff = min(backoff*2, MAX_POLL_BACKOFF)
+			case codes.Unavailable:
+				log.Printf("Orchestrator unavailable: %v", err)
+				backoff = min(backoff*2, MAX_POLL_BACKOFF)
+			default:
+				log.Printf("gRPC client error: %v", err)
+			}
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = time.Second
+
+		Slots.release(free - len(response.Calculations))
+		for _, gRPC_Calculation := range response.Calculations {
+			calc := calculationFromProto(gRPC_Calculation)
+			InFlight.add(calc)
+			Calculations <- calc
+		}
+	}
+}
+
+// register tells the orchestrator who we are and how many workers we have.
+// It returns our id and how often the orchestrator wants to hear from us.
+func register(agentId string) (string, time.Duration, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "agent"
+	}
+
+	response, err := grpcClient.RegisterAgent(context.TODO(), &pb.RegisterAgentRequest{
+		AgentId:        agentId,
+		Hostname:       hostname,
+		ComputingPower: int64(COMPUTING_POWER),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return response.AgentId, time.Duration(response.HeartbeatIntervalMs) * time.Millisecond, nil
+}
+
+// sendHeartbeats lets the orchestrator know we're alive, which also keeps
+// the leases on the calculations we're still working on from running out. It never returns.
+func sendHeartbeats(interval time.Duration) {
+	for range time.Tick(interval) {
+		response, err := grpcClient.Heartbeat(context.TODO(), &pb.HeartbeatRequest{
+			AgentId:   AgentId,
+			FreeSlots: int64(COMPUTING_POWER) - Busy.Load(),
+			InFlight:  InFlight.list(),
+		})
+
+		switch status.Code(err) {
+		case codes.OK:
+			Cancelled.cancel(response.CancelledTaskIds)
+		case codes.NotFound:
+			// The orchestrator lost track of us, e.g. its database was reset.
+			log.Println("The orchestrator doesn't know us anymore, registering again.")
+			if _, _, err := register(AgentId); err != nil {
+				log.Printf("Couldn't register with the orchestrator: %v", err)
+			}
+		default:
+			log.Printf("Heartbeat error: %v", err)
+		}
+	}
+}
+
+func calculationFromProto(calc *pb.Calculation) service.Calculation {
+	return service.Calculation{
+		Task_id:    int(calc.TaskId),
+		Node_id:    int(calc.NodeId),
+		RPN_string: calc.RPNString,
+		Status:     calc.Status,
+		Result:     calc.Result,
+		Mode:       calc.Mode,
+	}
+}
+
 func main() {
 	log.Println("The Agent is being launched...")
 	// Getting environment variables.
@@ -91,6 +482,17 @@ func main() {
 		TIME_SUBTRACTION_MS = cfg.TimeSubtractionMs
 		TIME_MULTIPLICATIONS_MS = cfg.TimeMultiplicationsMs
 		TIME_DIVISIONS_MS = cfg.TimeDivisionsMs
+		// Older config files don't have these yet.
+		if cfg.TimePowerMs != 0 {
+			TIME_POWER_MS = cfg.TimePowerMs
+		}
+		if cfg.TimeModuloMs != 0 {
+			TIME_MODULO_MS = cfg.TimeModuloMs
+		}
+		TIME_FUNCTION_MS = cfg.TimeFunctionsMs
+		if cfg.OrchestratorAddress != "" {
+			ORCHESTRATOR_ADDRESS = cfg.OrchestratorAddress
+		}
 	}
 
 	log.Printf("Computing power is: %d\n", COMPUTING_POWER)
@@ -98,61 +500,53 @@ func main() {
 	log.Printf("Subtraction time is: %d ms.\n", TIME_SUBTRACTION_MS)
 	log.Printf("Multiplication time is: %d ms.\n", TIME_MULTIPLICATIONS_MS)
 	log.Printf("Division time is: %d ms.\n", TIME_DIVISIONS_MS)
-
-	// Opening a connection to the db and creating the tables if necessary.
-	db, err := sql.Open("sqlite3", "../orchestrator/data.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// This is important!
-	// Foreign keys might not always be on by default.
-	// However, we heavily rely on them, so if they don't work, we're toast.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
-	if err != nil {
-		log.Fatal("Failed to enable foreign key constraints:", err)
+	log.Printf("Power time is: %d ms.\n", TIME_POWER_MS)
+	log.Printf("Modulo time is: %d ms.\n", TIME_MODULO_MS)
+	for name, ms := range TIME_FUNCTION_MS {
+		log.Printf("Function %s time is: %d ms.\n", name, ms)
 	}
+	log.Printf("Orchestrator address is: %s\n", ORCHESTRATOR_ADDRESS)
 
-	conn, err := grpc.NewClient("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// The orchestrator is the only one who touches the database.
+	// Everything the Agent needs goes through gRPC, so it can live on any machine.
+	conn, err := grpc.NewClient(ORCHESTRATOR_ADDRESS, grpc.WithTransportCredentials(insecure.NewCredentials()))
 
 	if err != nil {
 		log.Fatalf("Couldn't connect to gRPC: %v", err)
 	}
 
-	log.Println("Successfully connected to gRPC on :50051...")
+	log.Printf("Successfully connected to gRPC on %s...\n", ORCHESTRATOR_ADDRESS)
 	defer conn.Close()
 
 	grpcClient = pb.NewCalculatorServiceClient(conn)
 
+	// We can't do anything until the orchestrator knows about us.
+	var heartbeatInterval time.Duration
+	AgentId, heartbeatInterval, err = register("")
+	for err != nil {
+		log.Printf("Couldn't register with the orchestrator: %v. Retrying...", err)
+		time.Sleep(1 * time.Second)
+		AgentId, heartbeatInterval, err = register("")
+	}
+	log.Printf("Agent id is: %s\n", AgentId)
+	go sendHeartbeats(heartbeatInterval)
+
+	// Buffered, so handing out claimed work never blocks:
+	// there are never more claimed calculations than workers.
+	Calculations = make(chan service.Calculation, COMPUTING_POWER)
+	Slots = newWorkerSlots(COMPUTING_POWER)
 	for i := 0; i < COMPUTING_POWER; i++ {
 		go Calculate()
 	}
 
-	// Infinite loop that sends requests to the Orchestrator.
+	// Infinite loop that keeps a work session with the Orchestrator open.
 	for {
-		time.Sleep(1 * time.Second)
-
-		gRPC_Calculation, err := grpcClient.GetCalculation(context.TODO(), &pb.GetCalculationRequest{})
-		if err != nil {
-			if err == sql.ErrNoRows {
-				log.Printf("gRPC client error: no tasks.")
-			} else {
-				log.Printf("gRPC client error: %v", err)
-			}
-		} else {
-			newCalc := service.Calculation{
-				Task_id:    int(gRPC_Calculation.TaskId),
-				RPN_string: gRPC_Calculation.RPNString,
-				Status:     gRPC_Calculation.Status,
-				Result:     int(gRPC_Calculation.Result),
-			}
-			_, err = db.Exec("UPDATE tasks SET status = 'In Process' WHERE task_id = ? AND RPN_string = ?", newCalc.Task_id, newCalc.RPN_string)
-			if err != nil {
-				log.Printf("Calculation update error, skipping. %v", err)
-				continue
-			}
-			Calculations <- newCalc
+		err := runWorkSession()
+		if status.Code(err) == codes.Unimplemented {
+			log.Println("The orchestrator doesn't support work sessions, falling back to polling.")
+			pollForCalculations()
 		}
-
+		log.Printf("Work session error: %v. Reconnecting...", err)
+		time.Sleep(1 * time.Second)
 	}
 }
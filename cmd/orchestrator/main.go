@@ -3,14 +3,21 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"distributed-calculator/api/handler"
+	calculate "distributed-calculator/internal/logic"
 	"distributed-calculator/internal/service"
 	pb "distributed-calculator/proto"
 )
@@ -24,35 +31,235 @@ func NewServer() *Server {
 }
 
 func (s *Server) GetCalculation(ctx context.Context, in *pb.GetCalculationRequest) (*pb.GetCalculationResponse, error) {
-	calc, err := handler.GiveTask()
+	calcs, err := handler.GiveTasks(in.AgentId, int(in.MaxTasks))
+	if err == sql.ErrNoRows {
+		return &pb.GetCalculationResponse{}, status.Error(codes.NotFound, "no calculations are waiting")
+	}
 	if err != nil {
-		return &pb.GetCalculationResponse{}, err
+		log.Printf("Failed to give calculations to agent %s: %v\n", in.AgentId, err)
+		return &pb.GetCalculationResponse{}, status.Error(codes.Unavailable, "couldn't read the calculation queue")
+	}
+
+	response := &pb.GetCalculationResponse{}
+	for _, calc := range calcs {
+		response.Calculations = append(response.Calculations, calculationToProto(calc))
 	}
 
-	return &pb.GetCalculationResponse{
+	return response, nil
+}
+
+func calculationToProto(calc service.Calculation) *pb.Calculation {
+	return &pb.Calculation{
 		TaskId:    int64(calc.Task_id),
+		NodeId:    int64(calc.Node_id),
 		RPNString: calc.RPN_string,
 		Status:    calc.Status,
-		Result:    int64(calc.Result),
-	}, nil
+		Result:    calc.Result,
+		Mode:      calc.Mode,
+	}
 }
 
 func (s *Server) SendCalculation(ctx context.Context, out *pb.SendCalculationRequest) (*pb.SendCalculationResponse, error) {
 	calc := service.Calculation{
 		Task_id:    int(out.TaskId),
+		Node_id:    int(out.NodeId),
 		RPN_string: out.RPNString,
 		Status:     out.Status,
-		Result:     int(out.Result),
+		Result:     out.Result,
 	}
 
-	err := handler.TakeTask(calc)
-	if err != nil {
-		return &pb.SendCalculationResponse{}, err
+	err := handler.TakeTask(out.AgentId, calc)
+	switch {
+	case err == nil:
+	case errors.Is(err, handler.ErrBadCalculationStatus):
+		return &pb.SendCalculationResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, handler.ErrCalculationNotFound):
+		return &pb.SendCalculationResponse{}, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, handler.ErrAlreadyReported), errors.Is(err, handler.ErrTaskFinished), errors.Is(err, handler.ErrTaskCancelled), errors.Is(err, handler.ErrNotLeaseHolder):
+		return &pb.SendCalculationResponse{}, status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return &pb.SendCalculationResponse{}, status.Error(codes.Internal, err.Error())
 	}
 
 	return &pb.SendCalculationResponse{}, nil
 }
 
+func (s *Server) WorkSession(stream pb.CalculatorService_WorkSessionServer) error {
+	// The first message tells us who the agent is and how many workers it has free.
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	agentId := first.AgentId
+	free := first.FreeSlots
+	log.Printf("Agent %s opened a work session with %d free slot(s).\n", agentId, free)
+
+	slots := make(chan int64)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				done <- err
+				return
+			}
+			select {
+			case slots <- req.FreeSlots:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		if free <= 0 {
+			select {
+			case n := <-slots:
+				free += n
+				continue
+			case err := <-done:
+				return sessionEnded(agentId, err)
+			}
+		}
+
+		ready := handler.TasksReady()
+		calcs, err := handler.GiveTasks(agentId, int(free))
+		if err == nil {
+			// If sending fails the leases run out and somebody else gets the calculations.
+			for _, calc := range calcs {
+				err = stream.Send(&pb.WorkSessionResponse{Calculation: calculationToProto(calc)})
+				if err != nil {
+					return err
+				}
+				free--
+			}
+			continue
+		}
+
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to give a calculation to agent %s: %v\n", agentId, err)
+		}
+
+		// Nothing to do right now, so wait until something changes.
+		select {
+		case <-ready:
+		case n := <-slots:
+			free += n
+		case err := <-done:
+			return sessionEnded(agentId, err)
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (s *Server) RegisterAgent(ctx context.Context, in *pb.RegisterAgentRequest) (*pb.RegisterAgentResponse, error) {
+	if in.ComputingPower < 0 {
+		return &pb.RegisterAgentResponse{}, status.Error(codes.InvalidArgument, "computing power can't be negative")
+	}
+
+	agentId, err := handler.RegisterAgent(in.AgentId, in.Hostname, int(in.ComputingPower))
+	if err != nil {
+		log.Printf("Failed to register agent %s: %v\n", in.Hostname, err)
+		return &pb.RegisterAgentResponse{}, status.Error(codes.Internal, "couldn't register the agent")
+	}
+
+	return &pb.RegisterAgentResponse{
+		AgentId:             agentId,
+		HeartbeatIntervalMs: handler.HeartbeatInterval.Milliseconds(),
+	}, nil
+}
+
+func (s *Server) Heartbeat(ctx context.Context, in *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	inFlight := []service.Calculation{}
+	for _, calc := range in.InFlight {
+		inFlight = append(inFlight, service.Calculation{Task_id: int(calc.TaskId), Node_id: int(calc.NodeId)})
+	}
+
+	cancelled, err := handler.AgentHeartbeat(in.AgentId, int(in.FreeSlots), inFlight)
+	if errors.Is(err, handler.ErrAgentNotFound) {
+		return &pb.HeartbeatResponse{}, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		log.Printf("Failed to process heartbeat of agent %s: %v\n", in.AgentId, err)
+		return &pb.HeartbeatResponse{}, status.Error(codes.Internal, "couldn't process the heartbeat")
+	}
+
+	return &pb.HeartbeatResponse{CancelledTaskIds: cancelled}, nil
+}
+
+func sessionEnded(agentId string, err error) error {
+	if err == io.EOF {
+		log.Printf("Agent %s closed its work session.\n", agentId)
+		return nil
+	}
+	log.Printf("Work session of agent %s ended: %v\n", agentId, err)
+	return err
+}
+
+// columnType looks up the declared type of a column.
+// found is false if the table has no such column.
+func columnType(db *sql.DB, table, column string) (declared string, found bool, err error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return "", false, err
+		}
+		if strings.EqualFold(name, column) {
+			return columnType, true, nil
+		}
+	}
+	return "", false, rows.Err()
+}
+
+// addColumnIfMissing adds a column to an already existing table.
+// SQLite has no "ADD COLUMN IF NOT EXISTS", so we have to look it up ourselves.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	_, found, err := columnType(db, table, column)
+	if err != nil || found {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// changeColumnType gives an existing column a new type, keeping its values.
+// SQLite can't alter a column, so a new one takes the place of the old one.
+func changeColumnType(db *sql.DB, table, column, newType string) error {
+	declared, found, err := columnType(db, table, column)
+	if err != nil || !found || strings.EqualFold(declared, newType) {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old := column + "_old"
+	for _, statement := range []string{
+		fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, column, old),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, newType),
+		fmt.Sprintf("UPDATE %s SET %s = %s", table, column, old),
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, old),
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func main() {
 	mux := http.NewServeMux()
 	static := filepath.Join("..", "..")
@@ -63,27 +270,29 @@ func main() {
 	mux.HandleFunc("/", handler.TaskPage)
 	mux.Handle("/static/", fs)
 	mux.HandleFunc("/api/v1/calculate", handler.AddTask)
+	mux.HandleFunc("/api/v1/calculate/batch", handler.HandleBatch)
 	mux.HandleFunc("/api/v1/expressions", handler.HandleAllExpressions)
 	mux.HandleFunc("/api/v1/expressions/{id}", handler.HandleAllExpressions)
 	mux.HandleFunc("/api/v1/register", handler.HandleRegistration)
 	mux.HandleFunc("/api/v1/login", handler.HandleLogin)
 	mux.HandleFunc("/auth", handler.AuthPage)
 	mux.HandleFunc("/user", handler.UserHandler)
+	mux.HandleFunc("/api/v1/agents", handler.HandleAgents)
+	mux.HandleFunc("/api/v1/cache", handler.HandleResultCache)
 
 	// Opening a connection to the db and creating the tables if necessary.
-	db, err := sql.Open("sqlite3", "./data.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
+	// The handlers share it, see handler.SetDB.
 	// This is important!
 	// Foreign keys might not always be on by default.
 	// However, we heavily rely on them, so if they don't work, we're toast.
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
+	// PRAGMA foreign_keys only affects the connection it ran on,
+	// so it goes into the DSN, for every connection of the pool.
+	db, err := sql.Open("sqlite3", "./data.db?_foreign_keys=on")
 	if err != nil {
-		log.Fatal("Failed to enable foreign key constraints:", err)
+		log.Fatal(err)
 	}
+	defer db.Close()
+	handler.SetDB(db)
 
 	createUserTableSQL := `
     CREATE TABLE IF NOT EXISTS users (
@@ -104,8 +313,16 @@ func main() {
 		"status" TEXT NOT NULL,
 		"original_expression" TEXT NOT NULL,
 		"expression" TEXT NOT NULL,
-		"result" INTEGER,
+		"result" TEXT,
+		"mode" TEXT NOT NULL DEFAULT 'int64',
+		"variables" TEXT,
+		"optimized_expression" TEXT,
+		"idempotency_key" TEXT,
+		"request_hash" TEXT,
+		"created_at" INTEGER,
+		"finished_at" INTEGER,
 		"owner" INTEGER,
+		"root_node" INTEGER,
 		FOREIGN KEY(owner) REFERENCES users(id)
 	);`
 
@@ -119,9 +336,11 @@ func main() {
 	CREATE TABLE IF NOT EXISTS tasks (
 		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
 		"RPN_string" TEXT,
+		"mode" TEXT NOT NULL DEFAULT 'int64',
 		"status" TEXT,
 		"Result" TEXT,
 		"task_id" INTEGER,
+		"node_id" INTEGER,
 		FOREIGN KEY(task_id) REFERENCES expressions(id)
 	);`
 
@@ -130,6 +349,129 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Every expression is stored as a tree: nodes are numbers and operations,
+	// node_operands tells which nodes are the operands of an operation.
+	createNodesTableSQL := `
+	CREATE TABLE IF NOT EXISTS nodes (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"expression_id" INTEGER NOT NULL,
+		"op" TEXT,
+		"value" TEXT,
+		"state" TEXT NOT NULL,
+		FOREIGN KEY(expression_id) REFERENCES expressions(id)
+	);`
+
+	_, err = db.Exec(createNodesTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	createNodeOperandsTableSQL := `
+	CREATE TABLE IF NOT EXISTS node_operands (
+		"node_id" INTEGER NOT NULL,
+		"operand_id" INTEGER NOT NULL,
+		"position" INTEGER NOT NULL,
+		PRIMARY KEY(node_id, position),
+		FOREIGN KEY(node_id) REFERENCES nodes(id),
+		FOREIGN KEY(operand_id) REFERENCES nodes(id)
+	);
+	CREATE INDEX IF NOT EXISTS node_operands_operand ON node_operands(operand_id);`
+
+	_, err = db.Exec(createNodeOperandsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	createAgentsTableSQL := `
+	CREATE TABLE IF NOT EXISTS agents (
+		"id" TEXT NOT NULL PRIMARY KEY,
+		"hostname" TEXT,
+		"computing_power" INTEGER,
+		"free_slots" INTEGER,
+		"registered_at" INTEGER,
+		"last_seen" INTEGER
+	);`
+
+	_, err = db.Exec(createAgentsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Databases created by older versions don't have these columns yet.
+	err = addColumnIfMissing(db, "tasks", "agent_id", "TEXT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "tasks", "lease_deadline", "INTEGER")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "tasks", "node_id", "INTEGER")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "root_node", "INTEGER")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "mode", "TEXT NOT NULL DEFAULT 'int64'")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "tasks", "mode", "TEXT NOT NULL DEFAULT 'int64'")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "variables", "TEXT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "optimized_expression", "TEXT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "idempotency_key", "TEXT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "request_hash", "TEXT")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "created_at", "INTEGER")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = addColumnIfMissing(db, "expressions", "finished_at", "INTEGER")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The pages of GET /api/v1/expressions, for every way to sort them.
+	// The sort keys must be written exactly like in the handler, or SQLite won't use the indexes.
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS expressions_owner ON expressions(owner);
+	CREATE INDEX IF NOT EXISTS expressions_owner_created ON expressions(owner, COALESCE(created_at, 0));
+	CREATE INDEX IF NOT EXISTS expressions_owner_finished ON expressions(owner, COALESCE(finished_at, 9223372036854775807));`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Every user has their own Idempotency-Keys.
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS expressions_idempotency_key ON expressions(owner, idempotency_key)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Results used to be integers, which SQLite turns into floats
+	// as soon as they don't fit into int64.
+	err = changeColumnType(db, "expressions", "result", "TEXT")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Calculations whose results are known already don't go to the agents.
+	calculate.Results = handler.ResultCache
+
+	go handler.RunLeaseReaper(5 * time.Second)
+
 	go func() {
 		log.Println("HTTP server running on port 8080...")
 		http.ListenAndServe(":8080", mux)
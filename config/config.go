@@ -14,6 +14,12 @@ type Config struct {
 	TimeSubtractionMs     int
 	TimeMultiplicationsMs int
 	TimeDivisionsMs       int
+	TimePowerMs           int
+	TimeModuloMs          int
+	// TimeFunctionsMs is how long each function takes, by its name in lower case.
+	// It comes from keys like TIME_FUNCTION_SQRT_MS.
+	TimeFunctionsMs     map[string]int
+	OrchestratorAddress string
 }
 
 func LoadConfig(filepath string) (Config, error) {
@@ -23,7 +29,7 @@ func LoadConfig(filepath string) (Config, error) {
 	}
 	defer file.Close()
 
-	config := Config{}
+	config := Config{TimeFunctionsMs: map[string]int{}}
 	scanner := bufio.NewScanner(file)
 
 	for scanner.Scan() {
@@ -65,8 +71,29 @@ func LoadConfig(filepath string) (Config, error) {
 			if err != nil {
 				return Config{}, fmt.Errorf("invalid value for TIME_DIVISIONS_MS: %s", value)
 			}
+		case "TIME_POWER_MS":
+			config.TimePowerMs, err = strconv.Atoi(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid value for TIME_POWER_MS: %s", value)
+			}
+		case "TIME_MODULO_MS":
+			config.TimeModuloMs, err = strconv.Atoi(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid value for TIME_MODULO_MS: %s", value)
+			}
+		case "ORCHESTRATOR_ADDRESS":
+			config.OrchestratorAddress = value
 		default:
-			return Config{}, fmt.Errorf("unknown key: %s", key)
+			name, isFunction := strings.CutPrefix(key, "TIME_FUNCTION_")
+			name, hasSuffix := strings.CutSuffix(name, "_MS")
+			if !isFunction || !hasSuffix || name == "" {
+				return Config{}, fmt.Errorf("unknown key: %s", key)
+			}
+			ms, err := strconv.Atoi(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid value for %s: %s", key, value)
+			}
+			config.TimeFunctionsMs[strings.ToLower(name)] = ms
 		}
 	}
 
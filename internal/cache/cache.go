@@ -0,0 +1,119 @@
+// Package cache is an in-memory cache of calculation results.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache maps keys to results. Entries live for a while after they are put in,
+// and when there are too many, the ones that weren't used for the longest time go first.
+// It is safe for concurrent use.
+type Cache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	// order has the most recently used entries in front.
+	order   *list.List
+	entries map[string]*list.Element
+	hits    uint64
+	misses  uint64
+	evicted uint64
+	// now is time.Now, except in tests.
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// Stats is how well the cache is doing.
+type Stats struct {
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Evicted    uint64 `json:"evicted"`
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"max_entries"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+// New makes a cache of at most maxEntries entries, each one kept for ttl.
+// A cache with maxEntries <= 0 never keeps anything.
+func New(maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    map[string]*list.Element{},
+		now:        time.Now,
+	}
+}
+
+// Get returns the value for the key, if it's there and not expired.
+func (c *Cache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+	e := element.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.remove(element)
+		c.misses++
+		return "", false
+	}
+
+	c.order.MoveToFront(element)
+	c.hits++
+	return e.value, true
+}
+
+// Put stores the value for the key, making room for it if needed.
+func (c *Cache) Put(key, value string) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		e := element.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	for c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Back())
+		c.evicted++
+	}
+	c.entries[key] = c.order.PushFront(&entry{key, value, expires})
+}
+
+// Stats returns the counters of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evicted:    c.evicted,
+		Entries:    c.order.Len(),
+		MaxEntries: c.maxEntries,
+		TTLSeconds: int64(c.ttl.Seconds()),
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
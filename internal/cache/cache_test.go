@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheExpires(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := New(10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.Get("int64:1 2 +"); ok {
+		t.Fatal("got a value from an empty cache")
+	}
+	c.Put("int64:1 2 +", "3")
+
+	now = now.Add(59 * time.Second)
+	if value, ok := c.Get("int64:1 2 +"); !ok || value != "3" {
+		t.Fatalf("got %q, %v, want 3", value, ok)
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.Get("int64:1 2 +"); ok {
+		t.Fatal("got an expired value")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 0 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(2, time.Hour)
+	c.Put("a", "1")
+	c.Put("b", "2")
+	c.Get("a")
+	c.Put("c", "3")
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	c.Put("a", "10")
+	if value, _ := c.Get("a"); value != "10" {
+		t.Errorf("a wasn't updated: %q", value)
+	}
+	if stats := c.Stats(); stats.Entries != 2 || stats.Evicted != 1 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := New(0, time.Hour)
+	c.Put("a", "1")
+	if _, ok := c.Get("a"); ok {
+		t.Error("a cache of size 0 kept a value")
+	}
+}
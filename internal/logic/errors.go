@@ -0,0 +1,45 @@
+package calculate
+
+import "fmt"
+
+// Kinds of ParseError.
+const (
+	ErrKindEmpty                = "empty"
+	ErrKindInvalidCharacter     = "invalid_character"
+	ErrKindInvalidToken         = "invalid_token"
+	ErrKindInvalidNumber        = "invalid_number"
+	ErrKindMisplacedOperator    = "misplaced_operator"
+	ErrKindMissingOperator      = "missing_operator"
+	ErrKindMissingOperand       = "missing_operand"
+	ErrKindUnmatchedParenthesis = "unmatched_parenthesis"
+	ErrKindMisplacedComma       = "misplaced_comma"
+	ErrKindUnknownFunction      = "unknown_function"
+	ErrKindWrongArgumentCount   = "wrong_argument_count"
+	ErrKindUnboundVariable      = "unbound_variable"
+	ErrKindInvalidVariable      = "invalid_variable"
+)
+
+// ParseError tells what is wrong with an expression and where,
+// so the user can be shown the exact spot.
+type ParseError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Offset is the position of the failing token in the expression, in characters from 0.
+	// If the expression ended too early, it's the length of the expression.
+	Offset int `json:"offset"`
+	// Token is the failing token itself, empty at the end of the expression.
+	Token string `json:"token"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+}
+
+func newParseError(kind string, at token, format string, args ...any) *ParseError {
+	return &ParseError{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+		Offset:  at.offset,
+		Token:   at.text,
+	}
+}
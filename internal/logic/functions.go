@@ -0,0 +1,156 @@
+package calculate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrBadArgument is an argument a function can't take, like sqrt(-1).
+	ErrBadArgument = errors.New("BAD ARGUMENT")
+	// ErrInexact is a result that can't be written as a fraction, like sqrt(2) in rational mode.
+	ErrInexact = errors.New("NO EXACT RESULT")
+)
+
+// Function is a built-in function that can be called in expressions, e.g. max(3, 4*2).
+// It knows how to calculate itself with every kind of numbers we have.
+type Function struct {
+	// MinArgs and MaxArgs limit how many arguments the function takes.
+	// A negative MaxArgs means there's no upper limit.
+	MinArgs int
+	MaxArgs int
+
+	// Int is used in the int64 and bigint modes, Rat in the rational mode
+	// and Float in the float64 mode.
+	Int   func(args []*big.Int) (*big.Int, error)
+	Rat   func(args []*big.Rat) (*big.Rat, error)
+	Float func(args []float64) (float64, error)
+}
+
+// Functions is the registry of everything that can be called in expressions.
+var Functions = map[string]Function{
+	"abs": {
+		MinArgs: 1,
+		MaxArgs: 1,
+		Int: func(args []*big.Int) (*big.Int, error) {
+			return new(big.Int).Abs(args[0]), nil
+		},
+		Rat: func(args []*big.Rat) (*big.Rat, error) {
+			return new(big.Rat).Abs(args[0]), nil
+		},
+		Float: func(args []float64) (float64, error) {
+			return math.Abs(args[0]), nil
+		},
+	},
+	"sqrt": {
+		MinArgs: 1,
+		MaxArgs: 1,
+		// Like division, the integer square root drops the fractional part.
+		Int: func(args []*big.Int) (*big.Int, error) {
+			if args[0].Sign() < 0 {
+				return nil, ErrBadArgument
+			}
+			return new(big.Int).Sqrt(args[0]), nil
+		},
+		Rat: func(args []*big.Rat) (*big.Rat, error) {
+			if args[0].Sign() < 0 {
+				return nil, ErrBadArgument
+			}
+			num := new(big.Int).Sqrt(args[0].Num())
+			denom := new(big.Int).Sqrt(args[0].Denom())
+			result := new(big.Rat).SetFrac(num, denom)
+			if new(big.Rat).Mul(result, result).Cmp(args[0]) != 0 {
+				return nil, ErrInexact
+			}
+			return result, nil
+		},
+		Float: func(args []float64) (float64, error) {
+			if args[0] < 0 {
+				return 0, ErrBadArgument
+			}
+			return math.Sqrt(args[0]), nil
+		},
+	},
+	"min": {
+		MinArgs: 1,
+		MaxArgs: -1,
+		Int: func(args []*big.Int) (*big.Int, error) {
+			return pick(args, func(a, b *big.Int) bool { return a.Cmp(b) < 0 }), nil
+		},
+		Rat: func(args []*big.Rat) (*big.Rat, error) {
+			return pick(args, func(a, b *big.Rat) bool { return a.Cmp(b) < 0 }), nil
+		},
+		Float: func(args []float64) (float64, error) {
+			return pick(args, func(a, b float64) bool { return a < b }), nil
+		},
+	},
+	"max": {
+		MinArgs: 1,
+		MaxArgs: -1,
+		Int: func(args []*big.Int) (*big.Int, error) {
+			return pick(args, func(a, b *big.Int) bool { return a.Cmp(b) > 0 }), nil
+		},
+		Rat: func(args []*big.Rat) (*big.Rat, error) {
+			return pick(args, func(a, b *big.Rat) bool { return a.Cmp(b) > 0 }), nil
+		},
+		Float: func(args []float64) (float64, error) {
+			return pick(args, func(a, b float64) bool { return a > b }), nil
+		},
+	},
+}
+
+// pick returns the argument that is better than all the others.
+func pick[T any](args []T, better func(a, b T) bool) T {
+	best := args[0]
+	for _, arg := range args[1:] {
+		if better(arg, best) {
+			best = arg
+		}
+	}
+	return best
+}
+
+// IsFunction tells if there is a built-in function with the name.
+func IsFunction(name string) bool {
+	_, ok := Functions[name]
+	return ok
+}
+
+// checkArity makes sure the function can be called with that many arguments.
+func checkArity(name string, count int) error {
+	function, ok := Functions[name]
+	if !ok {
+		return fmt.Errorf("unknown function %s", name)
+	}
+	if count < function.MinArgs {
+		return fmt.Errorf("function %s takes at least %d argument(s), got %d", name, function.MinArgs, count)
+	}
+	if function.MaxArgs >= 0 && count > function.MaxArgs {
+		return fmt.Errorf("function %s takes at most %d argument(s), got %d", name, function.MaxArgs, count)
+	}
+	return nil
+}
+
+// FunctionToken is how a call is written in RPN: the name and the number
+// of arguments, so "3 8 max:2" is max(3, 8).
+func FunctionToken(name string, arity int) string {
+	return fmt.Sprintf("%s:%d", name, arity)
+}
+
+// ParseFunctionToken is the opposite of FunctionToken.
+// ok is false if the token isn't a call of a known function.
+func ParseFunctionToken(token string) (name string, arity int, ok bool) {
+	name, count, found := strings.Cut(token, ":")
+	if !found || !IsFunction(name) {
+		return "", 0, false
+	}
+	arity, err := strconv.Atoi(count)
+	if err != nil || checkArity(name, arity) != nil {
+		return "", 0, false
+	}
+	return name, arity, true
+}
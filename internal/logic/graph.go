@@ -0,0 +1,226 @@
+package calculate
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+)
+
+// States of the nodes in the nodes table.
+const (
+	// NodeWaiting means some operands of the node are not calculated yet.
+	NodeWaiting = "Waiting"
+	// NodeScheduled means the node is in the tasks table waiting for an agent.
+	NodeScheduled = "Scheduled"
+	// NodeDone means the value of the node is known.
+	NodeDone = "Done"
+)
+
+// SaveTree stores the expression tree in the nodes and node_operands tables
+// and fills in the ids of the nodes. Numbers are stored as done right away.
+// A node that is an operand of several other nodes is stored only once.
+func SaveTree(tx *sql.Tx, expressionId int, root *Node) error {
+	if root.Id != 0 {
+		return nil
+	}
+
+	for _, operand := range root.Operands {
+		if err := SaveTree(tx, expressionId, operand); err != nil {
+			return err
+		}
+	}
+
+	state := NodeWaiting
+	if root.IsNumber() {
+		state = NodeDone
+	}
+
+	res, err := tx.Exec(`INSERT INTO nodes (expression_id, op, value, state) VALUES (?, ?, ?, ?)`, expressionId, root.Op, root.Value, state)
+	if err != nil {
+		return err
+	}
+	root.Id, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for position, operand := range root.Operands {
+		_, err := tx.Exec(`INSERT INTO node_operands (node_id, operand_id, position) VALUES (?, ?, ?)`, root.Id, operand.Id, position)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ResultCache remembers the results of calculations by their CalculationKey.
+// It must be safe for concurrent use.
+type ResultCache interface {
+	Get(key string) (string, bool)
+	Put(key, value string)
+}
+
+// Results, if set, is looked into before a calculation goes to the agents,
+// so a calculation somebody has already done is not done again.
+var Results ResultCache
+
+// CalculationKey is the same for calculations that give the same result in the mode,
+// however the numbers are written: "2 + 3.0", "3 + 2" and "03 + 2" are all "5" in rational mode.
+func CalculationKey(rpn, mode string) string {
+	tokens := strings.Fields(rpn)
+	if len(tokens) == 0 {
+		return mode + ":"
+	}
+	operands, op := tokens[:len(tokens)-1], tokens[len(tokens)-1]
+
+	normalized := make([]string, len(operands))
+	for i, operand := range operands {
+		normalized[i] = operand
+		if value, err := EvalRPN([]string{operand}, mode); err == nil {
+			normalized[i] = value
+		}
+	}
+	if op == "+" || op == "*" {
+		slices.Sort(normalized)
+	}
+
+	return mode + ":" + strings.Join(append(normalized, op), " ")
+}
+
+// RememberResult puts the result of a calculation into Results, if there are any.
+func RememberResult(rpn, mode, result string) {
+	if Results != nil {
+		Results.Put(CalculationKey(rpn, mode), result)
+	}
+}
+
+// ScheduleNodes puts the given nodes of a saved tree into the tasks table.
+// Their operands must be done already, see ReadyNodes.
+// It returns how many calculations were added, the ones in Results are completed right away.
+func ScheduleNodes(tx *sql.Tx, expressionId int, nodes []*Node) (int, error) {
+	scheduled := 0
+	for _, node := range nodes {
+		count, err := scheduleNode(tx, expressionId, node.Id)
+		if err != nil {
+			return 0, err
+		}
+		scheduled += count
+	}
+	return scheduled, nil
+}
+
+// NodeValue returns the value of a node, done is false if it isn't known yet.
+func NodeValue(tx *sql.Tx, nodeId int64) (value string, done bool, err error) {
+	var state string
+	var stored sql.NullString
+	err = tx.QueryRow(`SELECT state, value FROM nodes WHERE id = ?`, nodeId).Scan(&state, &stored)
+	if err != nil {
+		return "", false, err
+	}
+	return stored.String, state == NodeDone, nil
+}
+
+// CompleteNode stores the value of a calculated node and schedules the nodes
+// that were only waiting for it. Nothing else in the expression is touched.
+// It returns how many calculations were added to the tasks table.
+func CompleteNode(tx *sql.Tx, nodeId int64, value string) (int, error) {
+	var expressionId int
+	err := tx.QueryRow(`UPDATE nodes SET value = ?, state = ? WHERE id = ? RETURNING expression_id`, value, NodeDone, nodeId).Scan(&expressionId)
+	if err != nil {
+		return 0, err
+	}
+
+	ready, err := queryNodeIds(tx, `SELECT DISTINCT p.id FROM node_operands o JOIN nodes p ON p.id = o.node_id
+		WHERE o.operand_id = ? AND p.state = ?
+		AND NOT EXISTS (SELECT 1 FROM node_operands po JOIN nodes c ON c.id = po.operand_id WHERE po.node_id = p.id AND c.state != ?)`,
+		nodeId, NodeWaiting, NodeDone)
+	if err != nil {
+		return 0, err
+	}
+
+	scheduled := 0
+	for _, id := range ready {
+		count, err := scheduleNode(tx, expressionId, id)
+		if err != nil {
+			return 0, err
+		}
+		scheduled += count
+	}
+
+	return scheduled, nil
+}
+
+// scheduleNode creates the calculation of a node whose operands are all done.
+// If the result is in Results already, the node is completed instead.
+// It returns how many calculations were added to the tasks table.
+func scheduleNode(tx *sql.Tx, expressionId int, nodeId int64) (int, error) {
+	var op, mode, state string
+	err := tx.QueryRow(`SELECT n.op, n.state, e.mode FROM nodes n JOIN expressions e ON e.id = n.expression_id WHERE n.id = ?`, nodeId).Scan(&op, &state, &mode)
+	if err != nil {
+		return 0, err
+	}
+	// Results from the cache complete nodes as we go, so the node may be done by now.
+	if state != NodeWaiting {
+		return 0, nil
+	}
+
+	rows, err := tx.Query(`SELECT c.value FROM node_operands o JOIN nodes c ON c.id = o.operand_id WHERE o.node_id = ? ORDER BY o.position`, nodeId)
+	if err != nil {
+		return 0, err
+	}
+	parts := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		parts = append(parts, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Functions can take any number of arguments, so the agent has to be told how many.
+	if IsFunction(op) {
+		op = FunctionToken(op, len(parts))
+	}
+	rpnString := strings.Join(append(parts, op), " ")
+
+	if Results != nil {
+		if result, ok := Results.Get(CalculationKey(rpnString, mode)); ok {
+			return CompleteNode(tx, nodeId, result)
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO tasks (task_id, node_id, RPN_string, mode, status, result) VALUES (?, ?, ?, ?, 'Waiting', '')`, expressionId, nodeId, rpnString, mode)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE nodes SET state = ? WHERE id = ?`, NodeScheduled, nodeId)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func queryNodeIds(tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,364 @@
+package calculate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Numeric modes of an expression.
+// The mode decides what the numbers look like and how the agents calculate them.
+const (
+	// ModeInt64 is the default. Division drops the remainder,
+	// and going out of the int64 range is an error instead of wrapping around.
+	ModeInt64 = "int64"
+	// ModeBigInt is integers of any size. Division drops the remainder.
+	ModeBigInt = "bigint"
+	// ModeRational is exact fractions, results look like "7/2".
+	// Decimal numbers are turned into fractions exactly, 0.1 is 1/10.
+	ModeRational = "rational"
+	// ModeFloat64 is ordinary floating point numbers.
+	ModeFloat64 = "float64"
+)
+
+var (
+	ErrDivisionByZero = errors.New("DIVISION BY ZERO")
+	ErrOverflow       = errors.New("OVERFLOW")
+	// ErrBadExponent is a negative power of an integer, a fractional power of a fraction
+	// or anything else that doesn't give a number, like (-8)^0.5.
+	ErrBadExponent = errors.New("BAD EXPONENT")
+)
+
+// maxResultBits is how long an integer (or a numerator) may get in bigint and rational modes,
+// so 2^1000000000 doesn't eat all the memory of an agent.
+const maxResultBits = 1 << 20
+
+// ValidMode tells if we know how to calculate in the mode.
+func ValidMode(mode string) bool {
+	switch mode {
+	case ModeInt64, ModeBigInt, ModeRational, ModeFloat64:
+		return true
+	}
+	return false
+}
+
+// ParseNumber checks that the number can be used in the mode.
+func ParseNumber(value, mode string) error {
+	var ok bool
+	switch mode {
+	case ModeInt64:
+		_, err := strconv.ParseInt(value, 10, 64)
+		ok = err == nil
+	case ModeBigInt:
+		_, ok = new(big.Int).SetString(value, 10)
+	case ModeRational:
+		_, ok = new(big.Rat).SetString(value)
+	case ModeFloat64:
+		f, err := strconv.ParseFloat(value, 64)
+		ok = err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+	default:
+		return fmt.Errorf("unknown numeric mode %q", mode)
+	}
+
+	if !ok {
+		return fmt.Errorf("%s is not a valid %s number", value, mode)
+	}
+	return nil
+}
+
+// EvalRPN calculates an RPN expression in the given mode.
+// The result is formatted the same way the numbers of the mode are written.
+func EvalRPN(tokens []string, mode string) (string, error) {
+	switch mode {
+	case ModeInt64:
+		return evalIntegers(tokens, true)
+	case ModeBigInt:
+		return evalIntegers(tokens, false)
+	case ModeRational:
+		return evalRationals(tokens)
+	case ModeFloat64:
+		return evalFloats(tokens)
+	}
+	return "", fmt.Errorf("unknown numeric mode %q", mode)
+}
+
+func evalIntegers(tokens []string, int64Only bool) (string, error) {
+	stack := []*big.Int{}
+	for _, token := range tokens {
+		if token == OpNegate {
+			if len(stack) < 1 {
+				return "", fmt.Errorf("no operand for %s", token)
+			}
+			stack[len(stack)-1] = new(big.Int).Neg(stack[len(stack)-1])
+			if int64Only && !stack[len(stack)-1].IsInt64() {
+				return "", ErrOverflow
+			}
+			continue
+		}
+		if name, arity, ok := ParseFunctionToken(token); ok {
+			if len(stack) < arity {
+				return "", fmt.Errorf("not enough arguments for function %s", name)
+			}
+			result, err := Functions[name].Int(stack[len(stack)-arity:])
+			if err != nil {
+				return "", err
+			}
+			if int64Only && !result.IsInt64() {
+				return "", ErrOverflow
+			}
+			stack = append(stack[:len(stack)-arity], result)
+			continue
+		}
+		if len(token) == 1 && isOperator(rune(token[0])) {
+			k := len(stack)
+			if k < 2 {
+				return "", fmt.Errorf("not enough operands for operator %s", token)
+			}
+			a, b := stack[k-2], stack[k-1]
+			stack = stack[:k-2]
+
+			result := new(big.Int)
+			switch token {
+			case "+":
+				result.Add(a, b)
+			case "-":
+				result.Sub(a, b)
+			case "*":
+				result.Mul(a, b)
+			case "/":
+				if b.Sign() == 0 {
+					return "", ErrDivisionByZero
+				}
+				result.Quo(a, b)
+			case "%":
+				if b.Sign() == 0 {
+					return "", ErrDivisionByZero
+				}
+				result.Rem(a, b)
+			case "^":
+				maxBits := int64(maxResultBits)
+				if int64Only {
+					maxBits = 64
+				}
+				power, err := powInt(a, b, maxBits)
+				if err != nil {
+					return "", err
+				}
+				result = power
+			}
+
+			if int64Only && !result.IsInt64() {
+				return "", ErrOverflow
+			}
+			stack = append(stack, result)
+			continue
+		}
+
+		n, ok := new(big.Int).SetString(token, 10)
+		if !ok {
+			return "", fmt.Errorf("invalid number %s", token)
+		}
+		if int64Only && !n.IsInt64() {
+			return "", ErrOverflow
+		}
+		stack = append(stack, n)
+	}
+
+	if len(stack) != 1 {
+		return "", fmt.Errorf("invalid RPN expression: stack has %d elements after processing", len(stack))
+	}
+	return stack[0].String(), nil
+}
+
+func evalRationals(tokens []string) (string, error) {
+	stack := []*big.Rat{}
+	for _, token := range tokens {
+		if token == OpNegate {
+			if len(stack) < 1 {
+				return "", fmt.Errorf("no operand for %s", token)
+			}
+			stack[len(stack)-1] = new(big.Rat).Neg(stack[len(stack)-1])
+			continue
+		}
+		if name, arity, ok := ParseFunctionToken(token); ok {
+			if len(stack) < arity {
+				return "", fmt.Errorf("not enough arguments for function %s", name)
+			}
+			result, err := Functions[name].Rat(stack[len(stack)-arity:])
+			if err != nil {
+				return "", err
+			}
+			stack = append(stack[:len(stack)-arity], result)
+			continue
+		}
+		if len(token) == 1 && isOperator(rune(token[0])) {
+			k := len(stack)
+			if k < 2 {
+				return "", fmt.Errorf("not enough operands for operator %s", token)
+			}
+			a, b := stack[k-2], stack[k-1]
+			stack = stack[:k-2]
+
+			result := new(big.Rat)
+			switch token {
+			case "+":
+				result.Add(a, b)
+			case "-":
+				result.Sub(a, b)
+			case "*":
+				result.Mul(a, b)
+			case "/":
+				if b.Sign() == 0 {
+					return "", ErrDivisionByZero
+				}
+				result.Quo(a, b)
+			case "%":
+				if b.Sign() == 0 {
+					return "", ErrDivisionByZero
+				}
+				// What's left after taking b away a whole number of times,
+				// the sign follows a just like with integers.
+				quotient := new(big.Rat).Quo(a, b)
+				whole := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+				result.Sub(a, new(big.Rat).Mul(b, new(big.Rat).SetInt(whole)))
+			case "^":
+				power, err := powRat(a, b)
+				if err != nil {
+					return "", err
+				}
+				result = power
+			}
+			stack = append(stack, result)
+			continue
+		}
+
+		n, ok := new(big.Rat).SetString(token)
+		if !ok {
+			return "", fmt.Errorf("invalid number %s", token)
+		}
+		stack = append(stack, n)
+	}
+
+	if len(stack) != 1 {
+		return "", fmt.Errorf("invalid RPN expression: stack has %d elements after processing", len(stack))
+	}
+	return stack[0].RatString(), nil
+}
+
+func evalFloats(tokens []string) (string, error) {
+	stack := []float64{}
+	for _, token := range tokens {
+		if token == OpNegate {
+			if len(stack) < 1 {
+				return "", fmt.Errorf("no operand for %s", token)
+			}
+			stack[len(stack)-1] = -stack[len(stack)-1]
+			continue
+		}
+		if name, arity, ok := ParseFunctionToken(token); ok {
+			if len(stack) < arity {
+				return "", fmt.Errorf("not enough arguments for function %s", name)
+			}
+			result, err := Functions[name].Float(stack[len(stack)-arity:])
+			if err != nil {
+				return "", err
+			}
+			stack = append(stack[:len(stack)-arity], result)
+			continue
+		}
+		if len(token) == 1 && isOperator(rune(token[0])) {
+			k := len(stack)
+			if k < 2 {
+				return "", fmt.Errorf("not enough operands for operator %s", token)
+			}
+			a, b := stack[k-2], stack[k-1]
+			stack = stack[:k-2]
+
+			var result float64
+			switch token {
+			case "+":
+				result = a + b
+			case "-":
+				result = a - b
+			case "*":
+				result = a * b
+			case "/":
+				if b == 0 {
+					return "", ErrDivisionByZero
+				}
+				result = a / b
+			case "%":
+				if b == 0 {
+					return "", ErrDivisionByZero
+				}
+				result = math.Mod(a, b)
+			case "^":
+				result = math.Pow(a, b)
+				if math.IsNaN(result) {
+					return "", ErrBadExponent
+				}
+			}
+
+			// Infinity can't be calculated with any further, so it's an error too.
+			if math.IsInf(result, 0) {
+				return "", ErrOverflow
+			}
+			stack = append(stack, result)
+			continue
+		}
+
+		n, err := strconv.ParseFloat(token, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return "", fmt.Errorf("invalid number %s", token)
+		}
+		stack = append(stack, n)
+	}
+
+	if len(stack) != 1 {
+		return "", fmt.Errorf("invalid RPN expression: stack has %d elements after processing", len(stack))
+	}
+	// The shortest form that reads back as the same number.
+	return strconv.FormatFloat(stack[0], 'g', -1, 64), nil
+}
+
+// powInt raises a to the power of b, unless the result would be longer than maxBits.
+func powInt(a, b *big.Int, maxBits int64) (*big.Int, error) {
+	if b.Sign() < 0 {
+		return nil, ErrBadExponent
+	}
+	// 0, 1 and -1 stay small whatever the power is.
+	if a.CmpAbs(big.NewInt(1)) > 0 {
+		if !b.IsInt64() || b.Int64() > maxBits || int64(a.BitLen()-1)*b.Int64() > maxBits {
+			return nil, ErrOverflow
+		}
+	}
+	return new(big.Int).Exp(a, b, nil), nil
+}
+
+// powRat raises a to a whole power b, which may be negative.
+func powRat(a, b *big.Rat) (*big.Rat, error) {
+	if !b.IsInt() {
+		return nil, ErrBadExponent
+	}
+	exponent := new(big.Int).Abs(b.Num())
+	if b.Sign() < 0 && a.Sign() == 0 {
+		return nil, ErrDivisionByZero
+	}
+
+	num, err := powInt(a.Num(), exponent, maxResultBits)
+	if err != nil {
+		return nil, err
+	}
+	denom, err := powInt(a.Denom(), exponent, maxResultBits)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(big.Rat).SetFrac(num, denom)
+	if b.Sign() < 0 {
+		result.Inv(result)
+	}
+	return result, nil
+}
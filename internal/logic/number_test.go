@@ -0,0 +1,114 @@
+package calculate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEvalRPNModes(t *testing.T) {
+	cases := []struct {
+		rpn    string
+		mode   string
+		result string
+		err    error
+	}{
+		{"7 2 /", ModeInt64, "3", nil},
+		{"42", ModeInt64, "42", nil},
+		{"-0", ModeBigInt, "0", nil},
+		{"0.50", ModeRational, "1/2", nil},
+		{"1.50", ModeFloat64, "1.5", nil},
+		{"7 2 /", ModeBigInt, "3", nil},
+		{"7 2 /", ModeRational, "7/2", nil},
+		{"7/2 1/2 +", ModeRational, "4", nil},
+		{"1 3 / 3 *", ModeRational, "1", nil},
+		{"-7 2 /", ModeInt64, "-3", nil},
+		{"9223372036854775807 1 -", ModeInt64, "9223372036854775806", nil},
+		{"9223372036854775807 1 +", ModeInt64, "", ErrOverflow},
+		{"-9223372036854775808 -1 /", ModeInt64, "", ErrOverflow},
+		{"4294967296 4294967296 *", ModeInt64, "", ErrOverflow},
+		{"99999999999999999999 1 +", ModeInt64, "", ErrOverflow},
+		{"9223372036854775807 1 +", ModeBigInt, "9223372036854775808", nil},
+		{"4294967296 4294967296 *", ModeBigInt, "18446744073709551616", nil},
+		{"1 0 /", ModeInt64, "", ErrDivisionByZero},
+		{"1 0 /", ModeBigInt, "", ErrDivisionByZero},
+		{"1 0 /", ModeRational, "", ErrDivisionByZero},
+		{"0.1 0.2 +", ModeRational, "3/10", nil},
+		{"7 2 /", ModeFloat64, "3.5", nil},
+		{"0.5 0.25 +", ModeFloat64, "0.75", nil},
+		{"-1.5 2 *", ModeFloat64, "-3", nil},
+		{"1e300 1e300 *", ModeFloat64, "", ErrOverflow},
+		{"1 0 /", ModeFloat64, "", ErrDivisionByZero},
+		{"2 10 ^", ModeInt64, "1024", nil},
+		{"-2 3 ^", ModeInt64, "-8", nil},
+		{"2 62 ^", ModeInt64, "4611686018427387904", nil},
+		{"2 63 ^", ModeInt64, "", ErrOverflow},
+		{"-2 63 ^", ModeInt64, "-9223372036854775808", nil},
+		{"1 99999999999999 ^", ModeInt64, "1", nil},
+		{"2 -1 ^", ModeInt64, "", ErrBadExponent},
+		{"2 100 ^", ModeBigInt, "1267650600228229401496703205376", nil},
+		{"2 9999999999 ^", ModeBigInt, "", ErrOverflow},
+		{"2 -2 ^", ModeRational, "1/4", nil},
+		{"2/3 2 ^", ModeRational, "4/9", nil},
+		{"4 1/2 ^", ModeRational, "", ErrBadExponent},
+		{"0 -1 ^", ModeRational, "", ErrDivisionByZero},
+		{"2 0.5 ^", ModeFloat64, "1.4142135623730951", nil},
+		{"-8 0.5 ^", ModeFloat64, "", ErrBadExponent},
+		{"10 400 ^", ModeFloat64, "", ErrOverflow},
+		{"7 3 %", ModeInt64, "1", nil},
+		{"-7 3 %", ModeInt64, "-1", nil},
+		{"7 0 %", ModeBigInt, "", ErrDivisionByZero},
+		{"7/2 1 %", ModeRational, "1/2", nil},
+		{"-7/2 1 %", ModeRational, "-1/2", nil},
+		{"7.5 2 %", ModeFloat64, "1.5", nil},
+		{"7 0 %", ModeFloat64, "", ErrDivisionByZero},
+		{"3 8 max:2", ModeInt64, "8", nil},
+		{"3 -8 2 min:3", ModeBigInt, "-8", nil},
+		{"1/2 1/3 max:2", ModeRational, "1/2", nil},
+		{"-2.5 abs:1", ModeFloat64, "2.5", nil},
+		{"-9223372036854775808 abs:1", ModeInt64, "", ErrOverflow},
+		{"17 sqrt:1", ModeInt64, "4", nil},
+		{"-1 sqrt:1", ModeBigInt, "", ErrBadArgument},
+		{"9/4 sqrt:1", ModeRational, "3/2", nil},
+		{"2 sqrt:1", ModeRational, "", ErrInexact},
+		{"2 sqrt:1", ModeFloat64, "1.4142135623730951", nil},
+	}
+
+	for _, tc := range cases {
+		result, err := EvalRPN(strings.Fields(tc.rpn), tc.mode)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s in %s: got error %v, want %v", tc.rpn, tc.mode, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s in %s: %v", tc.rpn, tc.mode, err)
+			continue
+		}
+		if result != tc.result {
+			t.Errorf("%s in %s = %s, want %s", tc.rpn, tc.mode, result, tc.result)
+		}
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	if err := ParseNumber("99999999999999999999", ModeInt64); err == nil {
+		t.Error("a number out of the int64 range was accepted in int64 mode")
+	}
+	if err := ParseNumber("99999999999999999999", ModeBigInt); err != nil {
+		t.Error(err)
+	}
+	if err := ParseNumber("7/2", ModeRational); err != nil {
+		t.Error(err)
+	}
+	if err := ParseNumber("1.5", ModeInt64); err == nil {
+		t.Error("a decimal number was accepted in int64 mode")
+	}
+	if err := ParseNumber("1.5", ModeFloat64); err != nil {
+		t.Error(err)
+	}
+	if err := ParseNumber("1", "float128"); err == nil {
+		t.Error("an unknown mode was accepted")
+	}
+}
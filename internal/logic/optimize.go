@@ -0,0 +1,135 @@
+package calculate
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Optimize rewrites the tree so the agents have less to sleep on:
+//
+//   - x + 0, 0 + x, x - 0, x * 1, 1 * x, x / 1 and x ^ 1 become just x;
+//   - x * 0 and 0 * x become 0 without calculating x at all, so 0 * (1 / 0) is 0.
+//     Not in float64 mode though, there x can be infinite and the sign of the zero depends on x;
+//   - minus in front of a number becomes a part of the number, and -(-x) is x;
+//   - operations that are written several times, like (a + b) in (a + b) * (a + b),
+//     become a single node, so they are calculated once.
+//
+// Everything else is still calculated by the agents. The tree is not changed,
+// a new one is returned, and it may be just a number.
+func Optimize(root *Node, mode string) *Node {
+	o := &optimizer{
+		mode:      mode,
+		common:    map[string]*Node{},
+		ids:       map[*Node]int{},
+		rewritten: map[*Node]*Node{},
+	}
+	return o.rewrite(root)
+}
+
+type optimizer struct {
+	mode string
+	// common maps what an operation looks like to the node that stands for it.
+	common map[string]*Node
+	ids    map[*Node]int
+	// rewritten is what every operation of the old tree became,
+	// the old tree may already have shared nodes.
+	rewritten map[*Node]*Node
+}
+
+func (o *optimizer) rewrite(n *Node) *Node {
+	if n.IsNumber() {
+		return n
+	}
+	if result, ok := o.rewritten[n]; ok {
+		return result
+	}
+
+	operands := make([]*Node, len(n.Operands))
+	for i, operand := range n.Operands {
+		operands[i] = o.rewrite(operand)
+	}
+	result := o.simplify(&Node{Op: n.Op, Operands: operands, Offset: n.Offset})
+
+	if !result.IsNumber() {
+		key := o.key(result)
+		if same, ok := o.common[key]; ok {
+			result = same
+		} else {
+			o.common[key] = result
+			o.ids[result] = len(o.ids) + 1
+		}
+	}
+
+	o.rewritten[n] = result
+	return result
+}
+
+// key is the same for two operations only if they calculate the same thing.
+// Operands are already shared at this point, so they are told apart by their ids.
+func (o *optimizer) key(n *Node) string {
+	parts := []string{n.Op}
+	for _, operand := range n.Operands {
+		if operand.IsNumber() {
+			parts = append(parts, operand.Value)
+		} else {
+			parts = append(parts, "#"+strconv.Itoa(o.ids[operand]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (o *optimizer) simplify(n *Node) *Node {
+	if n.Op == OpNegate {
+		operand := n.Operands[0]
+		if operand.IsNumber() {
+			return &Node{Value: negateNumber(operand.Value), Offset: n.Offset}
+		}
+		if operand.Op == OpNegate {
+			return operand.Operands[0]
+		}
+		return n
+	}
+	if len(n.Operands) != 2 {
+		return n
+	}
+
+	left, right := n.Operands[0], n.Operands[1]
+	switch n.Op {
+	case "+":
+		if isValue(left, 0) {
+			return right
+		}
+		if isValue(right, 0) {
+			return left
+		}
+	case "-":
+		if isValue(right, 0) {
+			return left
+		}
+	case "*":
+		if isValue(left, 1) {
+			return right
+		}
+		if isValue(right, 1) {
+			return left
+		}
+		if o.mode != ModeFloat64 && (isValue(left, 0) || isValue(right, 0)) {
+			return &Node{Value: "0", Offset: n.Offset}
+		}
+	case "/", "^":
+		if isValue(right, 1) {
+			return left
+		}
+	}
+	return n
+}
+
+// isValue tells if the node is a number equal to value, however it's written: 0, -0, 0.0.
+func isValue(n *Node, value int64) bool {
+	if !n.IsNumber() {
+		return false
+	}
+	number, ok := new(big.Rat).SetString(n.Value)
+	return ok && number.Cmp(big.NewRat(value, 1)) == 0
+}
@@ -0,0 +1,97 @@
+package calculate
+
+import "testing"
+
+func TestOptimize(t *testing.T) {
+	cases := []struct {
+		expression string
+		mode       string
+		optimized  string
+	}{
+		{"x * 1", ModeInt64, "7"},
+		{"(2 + 3) * 1", ModeInt64, "2 + 3"},
+		{"1 * (2 + 3) + 0", ModeInt64, "2 + 3"},
+		{"0 + (2 - 0) / 1", ModeInt64, "2"},
+		{"(2 * 3) ^ 1", ModeInt64, "2 * 3"},
+		{"0 * (2 ^ 100 + 1)", ModeInt64, "0"},
+		{"(2 + 3) * 0.0", ModeRational, "0"},
+		{"(2 + 3) * 0", ModeFloat64, "(2 + 3) * 0"},
+		{"(2 + 3) * 1", ModeFloat64, "2 + 3"},
+		{"1 + 0 * (1 / 0)", ModeBigInt, "1"},
+		{"-(2 * 1)", ModeInt64, "-2"},
+		{"-(-(2 + 3))", ModeInt64, "2 + 3"},
+		{"0 - x", ModeInt64, "0 - 7"},
+		{"2 * 2 + 2 * 2", ModeInt64, "2 * 2 + 2 * 2"},
+		{"1 - 1", ModeInt64, "1 - 1"},
+	}
+
+	for _, tc := range cases {
+		tree, err := Parse(tc.expression, map[string]string{"x": "7"})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expression, err)
+		}
+		original := treeRPN(tree)
+		if got := Format(Optimize(tree, tc.mode)); got != tc.optimized {
+			t.Errorf("%s in %s optimized to %q, want %q", tc.expression, tc.mode, got, tc.optimized)
+		}
+		if treeRPN(tree) != original {
+			t.Errorf("%s: the original tree was changed", tc.expression)
+		}
+	}
+}
+
+func TestOptimizeSharesCommonOperations(t *testing.T) {
+	tree, err := Parse("(1 + 2) * (1 + 2) - max(1 + 2, 3 * 4) * (3 * 4)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized := Optimize(tree, ModeInt64)
+
+	product := optimized.Operands[0]
+	if product.Operands[0] != product.Operands[1] {
+		t.Error("(1 + 2) wasn't shared")
+	}
+	call := optimized.Operands[1].Operands[0]
+	if call.Operands[0] != product.Operands[0] || call.Operands[1] != optimized.Operands[1].Operands[1] {
+		t.Error("operations under different parents weren't shared")
+	}
+
+	// Shared operations are scheduled once.
+	if ready := ReadyNodes(optimized); len(ready) != 2 {
+		t.Errorf("got %d ready operations, want 2", len(ready))
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, expression := range []string{
+		"1 - (2 - 3)",
+		"(1 - 2) - 3",
+		"8 / (4 / 2)",
+		"2 * (3 + 4)",
+		"(2 ^ 3) ^ 2",
+		"2 ^ 3 ^ 2",
+		"(-2) ^ 2",
+		"-2 ^ 2",
+		"2 ^ -(1 + 1)",
+		"-(2 + 3) * -(4 * 5)",
+		"-(-(1 + 2))",
+		"3 - -1",
+		"7 % (3 * 2)",
+		"max(1, min(2, 3) + 1, -(4 - 5)) ^ 2",
+		"-max(1, 2) ^ 2",
+	} {
+		tree, err := Parse(expression, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", expression, err)
+		}
+		formatted := Format(tree)
+		again, err := Parse(formatted, nil)
+		if err != nil {
+			t.Errorf("%s formatted as %q, which doesn't parse: %v", expression, formatted, err)
+			continue
+		}
+		if treeRPN(again) != treeRPN(tree) {
+			t.Errorf("%s formatted as %q, which means %q", expression, formatted, treeRPN(again))
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package calculate
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// OpNegate is the unary minus in front of something that isn't a number, like -(2+3).
+// Its node has a single operand.
+const OpNegate = "neg"
+
+// parser is a recursive descent parser of infix expressions:
+//
+//	expression = term { ("+" | "-") term }
+//	term       = unary { ("*" | "/" | "%") unary }
+//	unary      = ("+" | "-") unary | power
+//	power      = primary [ "^" unary ]
+//	primary    = number | call | variable | "(" expression ")"
+//	call       = name "(" [ expression { "," expression } ] ")"
+//	variable   = name
+//
+// Power is right-associative and binds tighter than unary minus,
+// so 2^3^2 is 2^(3^2) and -2^2 is -(2^2), like in maths.
+type parser struct {
+	tokens []token
+	pos    int
+	// end is where the expression ends, for errors about something missing at the end.
+	end       int
+	variables map[string]string
+	// unbound are the names of the variables that aren't in the variables map,
+	// in order of appearance, and where each of them is first used.
+	unbound      []string
+	unboundFirst token
+}
+
+// Parse checks an infix expression and turns it into a tree.
+// A minus right in front of a number becomes a part of the number,
+// any other unary minus becomes an OpNegate node. Unary plus is dropped.
+// Variables are replaced with their values right away.
+// If something is wrong with the expression, the error is a *ParseError.
+func Parse(expression string, variables map[string]string) (*Node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, end: utf8.RuneCountInString(expression), variables: variables}
+	if len(tokens) == 0 {
+		return nil, newParseError(ErrKindEmpty, p.current(), "got empty string")
+	}
+
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if current := p.current(); current.text != "" {
+		switch current.text {
+		case ")":
+			return nil, newParseError(ErrKindUnmatchedParenthesis, current, "unmatched closing parenthesis")
+		case ",":
+			return nil, newParseError(ErrKindMisplacedComma, current, "comma outside of a function call")
+		}
+		return nil, newParseError(ErrKindMissingOperator, current, "missing operator before %s", current.text)
+	}
+
+	if len(p.unbound) > 0 {
+		return nil, newParseError(ErrKindUnboundVariable, p.unboundFirst, "unbound variable(s): %s", strings.Join(p.unbound, ", "))
+	}
+
+	return root, nil
+}
+
+// current returns the current token, or an empty one at the end of the expression.
+func (p *parser) current() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{"", p.end}
+}
+
+func (p *parser) peek() string {
+	return p.current().text
+}
+
+func (p *parser) expression() (*Node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.current()
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Op: op.text, Operands: []*Node{left, right}, Offset: op.offset}
+	}
+
+	return left, nil
+}
+
+func (p *parser) term() (*Node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "%" {
+		op := p.current()
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Op: op.text, Operands: []*Node{left, right}, Offset: op.offset}
+	}
+
+	return left, nil
+}
+
+func (p *parser) unary() (*Node, error) {
+	switch p.peek() {
+	case "+":
+		p.pos++
+		return p.unary()
+	case "-":
+		minus := p.current()
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		// No need to bother an agent with -3.
+		if operand.IsNumber() {
+			return &Node{Value: negateNumber(operand.Value), Offset: minus.offset, source: operand.source}, nil
+		}
+		return &Node{Op: OpNegate, Operands: []*Node{operand}, Offset: minus.offset}, nil
+	}
+
+	return p.power()
+}
+
+func (p *parser) power() (*Node, error) {
+	base, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "^" {
+		return base, nil
+	}
+
+	op := p.current()
+	p.pos++
+	// The exponent may have its own power: that's what makes ^ right-associative.
+	exponent, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Op: "^", Operands: []*Node{base, exponent}, Offset: op.offset}, nil
+}
+
+func (p *parser) primary() (*Node, error) {
+	current := p.current()
+	text := current.text
+
+	switch {
+	case text == "":
+		return nil, newParseError(ErrKindMissingOperand, current, "expression ends with an operator")
+	case text == "(":
+		p.pos++
+		inner, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.closeParenthesis(current); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case text == ")" || text == ",":
+		return nil, newParseError(ErrKindMissingOperand, current, "missing operand before '%s'", text)
+	case isName(text):
+		if p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].text == "(" {
+			return p.call()
+		}
+		return p.variable()
+	case IsFloat(text):
+		p.pos++
+		return &Node{Value: text, Offset: current.offset}, nil
+	case len(text) == 1 && isOperator(rune(text[0])):
+		return nil, newParseError(ErrKindMisplacedOperator, current, "operator %s is misplaced", text)
+	}
+
+	return nil, newParseError(ErrKindInvalidToken, current, "invalid token %s", text)
+}
+
+// closeParenthesis expects the ")" for the "(" at open.
+func (p *parser) closeParenthesis(open token) error {
+	switch p.peek() {
+	case ")":
+		p.pos++
+		return nil
+	case "":
+		return newParseError(ErrKindUnmatchedParenthesis, open, "unmatched opening parenthesis")
+	}
+	return newParseError(ErrKindMissingOperator, p.current(), "missing operator before %s", p.peek())
+}
+
+func (p *parser) call() (*Node, error) {
+	name := p.current()
+	if !IsFunction(name.text) {
+		return nil, newParseError(ErrKindUnknownFunction, name, "unknown function %s", name.text)
+	}
+	p.pos++
+	open := p.current()
+	p.pos++
+
+	call := &Node{Op: name.text, Offset: name.offset}
+	if p.peek() != ")" {
+		for {
+			argument, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			call.Operands = append(call.Operands, argument)
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+	}
+
+	if err := p.closeParenthesis(open); err != nil {
+		return nil, err
+	}
+
+	if err := checkArity(name.text, len(call.Operands)); err != nil {
+		return nil, newParseError(ErrKindWrongArgumentCount, name, "%v", err)
+	}
+	return call, nil
+}
+
+func (p *parser) variable() (*Node, error) {
+	name := p.current()
+	p.pos++
+
+	value, ok := p.variables[name.text]
+	if !ok {
+		if len(p.unbound) == 0 {
+			p.unboundFirst = name
+		}
+		if !slices.Contains(p.unbound, name.text) {
+			p.unbound = append(p.unbound, name.text)
+		}
+		// Going on anyway, so all the unbound variables are reported at once.
+		return &Node{Value: "0", Offset: name.offset}, nil
+	}
+	if isName(value) || !IsFloat(value) {
+		return nil, newParseError(ErrKindInvalidVariable, name, "value of variable %s is not a number: %q", name.text, value)
+	}
+
+	return &Node{Value: value, Offset: name.offset, source: name}, nil
+}
+
+func negateNumber(value string) string {
+	if strings.HasPrefix(value, "-") {
+		return value[1:]
+	}
+	return "-" + value
+}
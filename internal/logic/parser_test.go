@@ -0,0 +1,210 @@
+package calculate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// treeRPN writes the whole tree in RPN, so it's easy to compare.
+func treeRPN(n *Node) string {
+	if n.IsNumber() {
+		return n.Value
+	}
+	parts := []string{}
+	for _, operand := range n.Operands {
+		parts = append(parts, treeRPN(operand))
+	}
+	return strings.Join(append(parts, n.Op), " ")
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		expression string
+		rpn        string
+	}{
+		{"2 + 2 * 2", "2 2 2 * +"},
+		{"(2 + 2) * 2", "2 2 + 2 *"},
+		{"1 - 2 - 3", "1 2 - 3 -"},
+		{"8 / 4 / 2", "8 4 / 2 /"},
+		{"1.5 * (2 + .25)", "1.5 2 .25 + *"},
+		{"-1 + 3", "-1 3 +"},
+		{"-1 * 3", "-1 3 *"},
+		{"3 * -1", "3 -1 *"},
+		{"3 - -1", "3 -1 -"},
+		{"--1", "1"},
+		{"+1 + +2", "1 2 +"},
+		{"-(7)", "-7"},
+		{"-(2+3)", "2 3 + neg"},
+		{"-(2+3) * -(4)", "2 3 + neg -4 *"},
+		{"2 * -(3 - -(1 + 1))", "2 3 1 1 + neg - neg *"},
+		{"42", "42"},
+		{"(7)", "7"},
+		{"2 ^ 3 ^ 2", "2 3 2 ^ ^"},
+		{"(2 ^ 3) ^ 2", "2 3 ^ 2 ^"},
+		{"-2 ^ 2", "2 2 ^ neg"},
+		{"(-2) ^ 2", "-2 2 ^"},
+		{"2 ^ -1", "2 -1 ^"},
+		{"2 * 3 ^ 2", "2 3 2 ^ *"},
+		{"7 % 3 * 2", "7 3 % 2 *"},
+		{"1 + 7 % 3", "1 7 3 % +"},
+		{"max(3, 4*2)", "3 4 2 * max"},
+		{"abs(-5) + sqrt(16)", "-5 abs 16 sqrt +"},
+		{"min(1)", "1 min"},
+		{"max(1, min(2, 3), -(4), 5 ^ 2)", "1 2 3 min -4 5 2 ^ max"},
+		{"-max(1, 2)", "1 2 max neg"},
+	}
+
+	for _, tc := range cases {
+		tree, err := Parse(tc.expression, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expression, err)
+			continue
+		}
+		if got := treeRPN(tree); got != tc.rpn {
+			t.Errorf("%s parsed as %q, want %q", tc.expression, got, tc.rpn)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"   ",
+		"1 +",
+		"* 2",
+		"1 2",
+		"(1 + 2",
+		"1 + 2)",
+		"()",
+		"2(3)",
+		"(1)(2)",
+		"1.2.3 + 1",
+		"2 ^",
+		"^ 2",
+		"2 % % 3",
+		"max()",
+		"sqrt(1, 2)",
+		"foo(1)",
+		"sqrt 4",
+		"max(1,)",
+		"max(1 2)",
+		"max(1, 2",
+		"1, 2",
+		"inf",
+		"NaN + 1",
+		"2x",
+		"abc",
+	} {
+		if _, err := Parse(expression, nil); err == nil {
+			t.Errorf("%q was accepted", expression)
+		}
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	variables := map[string]string{"x": "3", "y": "-2.5", "rate_1": "7", "max": "10"}
+
+	cases := []struct {
+		expression string
+		rpn        string
+	}{
+		{"x * x", "3 3 *"},
+		{"-y + rate_1", "2.5 7 +"},
+		{"x ^ 2 - y", "3 2 ^ -2.5 -"},
+		{"max(x, max)", "3 10 max"},
+		{"-(x)", "-3"},
+	}
+	for _, tc := range cases {
+		tree, err := Parse(tc.expression, variables)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expression, err)
+			continue
+		}
+		if got := treeRPN(tree); got != tc.rpn {
+			t.Errorf("%s parsed as %q, want %q", tc.expression, got, tc.rpn)
+		}
+	}
+
+	_, err := Parse("a * x + b - a", variables)
+	if err == nil || !strings.Contains(err.Error(), "a, b") {
+		t.Errorf("unbound variables a and b weren't named: %v", err)
+	}
+
+	_, err = Parse("x + z", map[string]string{"x": "1", "z": "abc"})
+	if err == nil || !strings.Contains(err.Error(), "z") {
+		t.Errorf("a variable that isn't a number was accepted: %v", err)
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	cases := []struct {
+		expression string
+		kind       string
+		offset     int
+		token      string
+	}{
+		{"", ErrKindEmpty, 0, ""},
+		{"1 + 2 $ 3", ErrKindInvalidCharacter, 6, "$"},
+		{"1 +", ErrKindMissingOperand, 3, ""},
+		{"1 + * 2", ErrKindMisplacedOperator, 4, "*"},
+		{"12 34", ErrKindMissingOperator, 3, "34"},
+		{"2 (3)", ErrKindMissingOperator, 2, "("},
+		{"(1 + 2", ErrKindUnmatchedParenthesis, 0, "("},
+		{"1 + (2 * (3 - 4)", ErrKindUnmatchedParenthesis, 4, "("},
+		{"1 + 2)", ErrKindUnmatchedParenthesis, 5, ")"},
+		{"1 + ()", ErrKindMissingOperand, 5, ")"},
+		{"1, 2", ErrKindMisplacedComma, 1, ","},
+		{"1.2.3 + 1", ErrKindInvalidToken, 0, "1.2.3"},
+		{"2 * foo(1)", ErrKindUnknownFunction, 4, "foo"},
+		{"1 + sqrt(1, 2)", ErrKindWrongArgumentCount, 4, "sqrt"},
+		{"x + y * a + b + a", ErrKindUnboundVariable, 0, "x"},
+		{"√4", ErrKindInvalidCharacter, 0, "√"},
+		{"√4 + ", ErrKindInvalidCharacter, 0, "√"},
+		{"(√)", ErrKindInvalidCharacter, 1, "√"},
+		{"ж + 1 +", ErrKindMissingOperand, 7, ""},
+	}
+
+	for _, tc := range cases {
+		_, err := Parse(tc.expression, nil)
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("%q: got %v, want a ParseError", tc.expression, err)
+			continue
+		}
+		if parseError.Kind != tc.kind || parseError.Offset != tc.offset || parseError.Token != tc.token {
+			t.Errorf("%q: got %s at %d (%q), want %s at %d (%q)", tc.expression,
+				parseError.Kind, parseError.Offset, parseError.Token, tc.kind, tc.offset, tc.token)
+		}
+	}
+
+	_, err := Parse("x + y * a + b + a", map[string]string{"y": "1"})
+	if err == nil || !strings.Contains(err.Error(), "x, a, b") {
+		t.Errorf("unbound variables weren't all named: %v", err)
+	}
+}
+
+func TestValidateNumbersPosition(t *testing.T) {
+	tree, err := Parse("1 + 2 * 99999999999999999999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateNumbers(tree, ModeInt64)
+	var parseError *ParseError
+	if !errors.As(err, &parseError) || parseError.Kind != ErrKindInvalidNumber || parseError.Offset != 8 {
+		t.Errorf("got %v, want an invalid number at offset 8", err)
+	}
+	if err := ValidateNumbers(tree, ModeBigInt); err != nil {
+		t.Error(err)
+	}
+
+	// A variable is pointed at by its name, that's what is in the expression.
+	tree, err = Parse("2 * -price", map[string]string{"price": "19.99"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateNumbers(tree, ModeInt64)
+	if !errors.As(err, &parseError) || parseError.Token != "price" || parseError.Offset != 5 {
+		t.Errorf("got %+v, want price at offset 5", err)
+	}
+}
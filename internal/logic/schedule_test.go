@@ -0,0 +1,342 @@
+package calculate
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var scheduleCases = []struct {
+	expression string
+	firstRound int
+	rounds     int
+	result     int
+}{
+	{"1+2", 1, 1, 3},
+	{"1+2+3+4", 1, 3, 10},
+	{"1*2+3*4", 2, 2, 14},
+	{"(1+2)*(3+4)", 2, 2, 21},
+	{"(1+2)*(3+4)+(5+6)*(7+8)", 4, 3, 186},
+	{"1+2*3-4/2", 2, 3, 5},
+	{"((1+2)*3+4)*(5-6/3)", 2, 4, 39},
+	{"1+(2+(3+(4+5)))", 1, 4, 15},
+	{"-(1+2)*-(3+4)", 2, 3, 21},
+	{"max(1+2, 3*4, 5-6) + sqrt(16)", 4, 3, 16},
+}
+
+// depth is the length of the critical path: the longest chain of operations.
+func depth(n *Node) int {
+	if n.IsNumber() {
+		return 0
+	}
+	longest := 0
+	for _, operand := range n.Operands {
+		longest = max(longest, depth(operand))
+	}
+	return longest + 1
+}
+
+func buildTree(t *testing.T, expression string) *Node {
+	t.Helper()
+	tree, err := Parse(expression, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", expression, err)
+	}
+	return tree
+}
+
+func nodeRPN(n *Node) string {
+	rpn := ""
+	for _, operand := range n.Operands {
+		rpn += operand.Value + " "
+	}
+	if IsFunction(n.Op) {
+		return rpn + FunctionToken(n.Op, len(n.Operands))
+	}
+	return rpn + n.Op
+}
+
+// Calculating every ready node at once must finish the expression in as many
+// rounds as the critical path is long.
+func TestReadyNodesReachCriticalPath(t *testing.T) {
+	for _, tc := range scheduleCases {
+		tree := buildTree(t, tc.expression)
+		if got := depth(tree); got != tc.rounds {
+			t.Fatalf("%s: depth is %d, want %d", tc.expression, got, tc.rounds)
+		}
+
+		rounds := 0
+		for !tree.IsNumber() {
+			ready := ReadyNodes(tree)
+			if rounds == 0 && len(ready) != tc.firstRound {
+				t.Errorf("%s: %d nodes ready at the start, want %d", tc.expression, len(ready), tc.firstRound)
+			}
+			if len(ready) == 0 {
+				t.Fatalf("%s: nothing ready in round %d", tc.expression, rounds+1)
+			}
+			for _, node := range ready {
+				result, err := EvalRPN(strings.Fields(nodeRPN(node)), ModeInt64)
+				if err != nil {
+					t.Fatalf("%s: %v", tc.expression, err)
+				}
+				node.Op, node.Value, node.Operands = "", result, nil
+			}
+			rounds++
+		}
+
+		if rounds != tc.rounds {
+			t.Errorf("%s: finished in %d rounds, want %d", tc.expression, rounds, tc.rounds)
+		}
+		if tree.Value != strconv.Itoa(tc.result) {
+			t.Errorf("%s = %s, want %d", tc.expression, tree.Value, tc.result)
+		}
+	}
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+	CREATE TABLE expressions (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"mode" TEXT NOT NULL
+	);
+	CREATE TABLE tasks (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"RPN_string" TEXT,
+		"mode" TEXT,
+		"status" TEXT,
+		"Result" TEXT,
+		"task_id" INTEGER,
+		"node_id" INTEGER
+	);
+	CREATE TABLE nodes (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"expression_id" INTEGER NOT NULL,
+		"op" TEXT,
+		"value" TEXT,
+		"state" TEXT NOT NULL
+	);
+	CREATE TABLE node_operands (
+		"node_id" INTEGER NOT NULL,
+		"operand_id" INTEGER NOT NULL,
+		"position" INTEGER NOT NULL,
+		PRIMARY KEY(node_id, position)
+	);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// The same through the database: every round the agents take everything
+// that is waiting, and CompleteNode schedules the next round.
+func TestScheduledTasksReachCriticalPath(t *testing.T) {
+	db := openTestDB(t)
+
+	for i, tc := range scheduleCases {
+		expressionId := i + 1
+		tree := buildTree(t, tc.expression)
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec(`INSERT INTO expressions (id, mode) VALUES (?, ?)`, expressionId, ModeInt64); err != nil {
+			t.Fatal(err)
+		}
+		if err := SaveTree(tx, expressionId, tree); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ScheduleNodes(tx, expressionId, ReadyNodes(tree)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		rounds := 0
+		for {
+			rows, err := db.Query(`SELECT id, node_id, RPN_string FROM tasks WHERE task_id = ? AND status = 'Waiting'`, expressionId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			type task struct {
+				id     int64
+				nodeId int64
+				rpn    string
+			}
+			waiting := []task{}
+			for rows.Next() {
+				var w task
+				if err := rows.Scan(&w.id, &w.nodeId, &w.rpn); err != nil {
+					t.Fatal(err)
+				}
+				waiting = append(waiting, w)
+			}
+			rows.Close()
+
+			if len(waiting) == 0 {
+				break
+			}
+			if rounds == 0 && len(waiting) != tc.firstRound {
+				t.Errorf("%s: %d tasks waiting at the start, want %d", tc.expression, len(waiting), tc.firstRound)
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range waiting {
+				result, err := EvalRPN(strings.Fields(w.rpn), ModeInt64)
+				if err != nil {
+					t.Fatalf("%s: %v", tc.expression, err)
+				}
+				if _, err := tx.Exec(`UPDATE tasks SET status = 'Finished', Result = ? WHERE id = ?`, result, w.id); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := CompleteNode(tx, w.nodeId, result); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			rounds++
+		}
+
+		if rounds != tc.rounds {
+			t.Errorf("%s: finished in %d rounds, want %d", tc.expression, rounds, tc.rounds)
+		}
+
+		var state, value string
+		err = db.QueryRow(`SELECT state, value FROM nodes WHERE id = ?`, tree.Id).Scan(&state, &value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != NodeDone || value != strconv.Itoa(tc.result) {
+			t.Errorf("%s: root is %s with %s, want %s with %d", tc.expression, state, value, NodeDone, tc.result)
+		}
+	}
+}
+
+type mapCache map[string]string
+
+func (c mapCache) Get(key string) (string, bool) {
+	value, ok := c[key]
+	return value, ok
+}
+
+func (c mapCache) Put(key, value string) {
+	c[key] = value
+}
+
+func TestCalculationKey(t *testing.T) {
+	same := [][2]string{
+		{"2 3 +", "3 2 +"},
+		{"2 3 *", "3 2 *"},
+		{"03 2 +", "3 2 +"},
+		{"-0 1 -", "0 1 -"},
+		{"1 2 3 max:3", "1 2 3 max:3"},
+	}
+	for _, pair := range same {
+		if CalculationKey(pair[0], ModeInt64) != CalculationKey(pair[1], ModeInt64) {
+			t.Errorf("%q and %q have different keys", pair[0], pair[1])
+		}
+	}
+	if CalculationKey("0.50 1 +", ModeRational) != CalculationKey("1 1/2 +", ModeRational) {
+		t.Error("0.50 and 1/2 have different keys in rational mode")
+	}
+
+	different := [][2]string{
+		{"2 3 -", "3 2 -"},
+		{"2 3 /", "3 2 /"},
+		{"2 3 ^", "3 2 ^"},
+		{"1 2 max:2", "1 2 min:2"},
+	}
+	for _, pair := range different {
+		if CalculationKey(pair[0], ModeInt64) == CalculationKey(pair[1], ModeInt64) {
+			t.Errorf("%q and %q have the same key", pair[0], pair[1])
+		}
+	}
+	if CalculationKey("7 2 /", ModeInt64) == CalculationKey("7 2 /", ModeRational) {
+		t.Error("different modes have the same key")
+	}
+}
+
+func TestCachedResultsAreNotScheduled(t *testing.T) {
+	db := openTestDB(t)
+	Results = mapCache{}
+	t.Cleanup(func() { Results = nil })
+	RememberResult("1 2 +", ModeInt64, "3")
+	RememberResult("3 4 *", ModeInt64, "12")
+
+	save := func(expressionId int, expression string) (*Node, int) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		tree := buildTree(t, expression)
+		if _, err := tx.Exec(`INSERT INTO expressions (id, mode) VALUES (?, ?)`, expressionId, ModeInt64); err != nil {
+			t.Fatal(err)
+		}
+		if err := SaveTree(tx, expressionId, tree); err != nil {
+			t.Fatal(err)
+		}
+		scheduled, err := ScheduleNodes(tx, expressionId, ReadyNodes(tree))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		return tree, scheduled
+	}
+
+	// (2 + 1) * 4 is known, only 5 - 1 goes to the agents.
+	tree, scheduled := save(1, "(2 + 1) * 4 + (5 - 1)")
+	if scheduled != 1 {
+		t.Errorf("scheduled %d calculations, want 1", scheduled)
+	}
+	var rpn string
+	if err := db.QueryRow(`SELECT RPN_string FROM tasks WHERE task_id = 1`).Scan(&rpn); err != nil {
+		t.Fatal(err)
+	}
+	if rpn != "5 1 -" {
+		t.Errorf("scheduled %q, want 5 1 -", rpn)
+	}
+	product := tree.Operands[0]
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if value, done, err := NodeValue(tx, product.Id); err != nil || !done || value != "12" {
+		t.Errorf("(2 + 1) * 4 is %q, done %v, %v", value, done, err)
+	}
+	tx.Rollback()
+
+	// Everything is known, the expression is done without any tasks.
+	tree, scheduled = save(2, "1 + 2")
+	if scheduled != 0 {
+		t.Errorf("scheduled %d calculations, want 0", scheduled)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if value, done, err := NodeValue(tx, tree.Id); err != nil || !done || value != "3" {
+		t.Errorf("1 + 2 is %q, done %v, %v", value, done, err)
+	}
+}
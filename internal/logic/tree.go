@@ -0,0 +1,132 @@
+package calculate
+
+import "strings"
+
+// Node is a node of an expression tree.
+// Numbers are leaves, operators have their operands in order.
+type Node struct {
+	// Id is the id of the node in the database, zero until it is saved.
+	Id       int64
+	Op       string
+	Value    string
+	Operands []*Node
+	// Offset is where the node starts in the expression it was parsed from, in characters.
+	Offset int
+	// source is what a number was written as in the expression, if not the number itself,
+	// e.g. the name of the variable it is the value of.
+	source token
+}
+
+func (n *Node) IsNumber() bool {
+	return n.Op == ""
+}
+
+// ReadyNodes returns every operation of the tree whose operands are all numbers,
+// each one once. All of them can be calculated at the same time.
+func ReadyNodes(root *Node) []*Node {
+	ready := []*Node{}
+	seen := map[*Node]bool{}
+
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.IsNumber() || seen[n] {
+			return
+		}
+		seen[n] = true
+
+		operandsReady := true
+		for _, operand := range n.Operands {
+			if !operand.IsNumber() {
+				operandsReady = false
+				walk(operand)
+			}
+		}
+		if operandsReady {
+			ready = append(ready, n)
+		}
+	}
+	walk(root)
+
+	return ready
+}
+
+// ValidateNumbers checks that every number of the tree can be used in the mode.
+// The error is a *ParseError pointing at the first bad number.
+func ValidateNumbers(root *Node, mode string) error {
+	if root.IsNumber() {
+		if err := ParseNumber(root.Value, mode); err != nil {
+			at := token{root.Value, root.Offset}
+			if root.source.text != "" {
+				at = root.source
+			}
+			return newParseError(ErrKindInvalidNumber, at, "%v", err)
+		}
+		return nil
+	}
+	for _, operand := range root.Operands {
+		if err := ValidateNumbers(operand, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format writes the tree back as an infix expression, with only the parentheses it needs.
+// Parse of the result gives the same tree.
+func Format(n *Node) string {
+	switch {
+	case n.IsNumber():
+		return n.Value
+	case n.Op == OpNegate:
+		return "-" + formatOperand(n.Operands[0], precedence(n.Operands[0]) < precedencePower)
+	case IsFunction(n.Op):
+		arguments := []string{}
+		for _, operand := range n.Operands {
+			arguments = append(arguments, Format(operand))
+		}
+		return n.Op + "(" + strings.Join(arguments, ", ") + ")"
+	}
+
+	left, right := n.Operands[0], n.Operands[1]
+	var leftParentheses, rightParentheses bool
+	if n.Op == "^" {
+		// Right-associative, and the exponent may have a unary minus.
+		leftParentheses = precedence(left) <= precedence(n)
+		rightParentheses = precedence(right) < precedenceUnary
+	} else {
+		leftParentheses = precedence(left) < precedence(n)
+		rightParentheses = precedence(right) <= precedence(n)
+	}
+	return formatOperand(left, leftParentheses) + " " + n.Op + " " + formatOperand(right, rightParentheses)
+}
+
+func formatOperand(n *Node, parentheses bool) string {
+	if parentheses {
+		return "(" + Format(n) + ")"
+	}
+	return Format(n)
+}
+
+// How tightly the nodes hold together when written in infix, see the grammar of the parser.
+const (
+	precedenceSum = iota + 1
+	precedenceProduct
+	precedenceUnary
+	precedencePower
+	precedencePrimary
+)
+
+// precedence of the node. A negative number is like a unary minus: (-2) ^ 2 isn't -2 ^ 2.
+func precedence(n *Node) int {
+	switch {
+	case n.IsNumber() && strings.HasPrefix(n.Value, "-"), n.Op == OpNegate:
+		return precedenceUnary
+	case n.Op == "+" || n.Op == "-":
+		return precedenceSum
+	case n.Op == "*" || n.Op == "/" || n.Op == "%":
+		return precedenceProduct
+	case n.Op == "^":
+		return precedencePower
+	}
+	return precedencePrimary
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"github.com/golang-jwt/jwt/v5"
@@ -13,15 +14,72 @@ type Task struct {
 	Status              string `json:"status"`
 	Original_Expression string `json:"original_expression"`
 	Expression          string `json:"expression"`
-	Result              int    `json:"result"`
-	Owner               string `json:"owner"`
+	Result              string `json:"result"`
+	Mode                string `json:"mode"`
+	// Variables are the values of the names used in the expression, like {"x": 3}.
+	Variables map[string]json.Number `json:"variables,omitempty"`
+	// Optimized_Expression is what was actually calculated, empty if the expression wasn't simplified.
+	Optimized_Expression string `json:"optimized_expression,omitempty"`
+	// Created_At and Finished_At are RFC 3339 times. Cancelled expressions count as finished.
+	Created_At  string `json:"created_at,omitempty"`
+	Finished_At string `json:"finished_at,omitempty"`
+	Owner       string `json:"owner"`
+}
+
+// TaskRequest is what a client sends to have an expression calculated.
+// Everything else about the expression is up to the server.
+type TaskRequest struct {
+	Expression string                 `json:"expression"`
+	Mode       string                 `json:"mode"`
+	Variables  map[string]json.Number `json:"variables,omitempty"`
+	// Optimize is whether the expression is simplified before it goes to the agents, true if not given.
+	Optimize *bool `json:"optimize,omitempty"`
+}
+
+// TaskStatus is the id of an expression, what is going on with it and where to find it.
+type TaskStatus struct {
+	Id     int    `json:"id"`
+	Status string `json:"status"`
+	// Links are where to find the expression, e.g. "self": "/api/v1/expressions/1".
+	Links map[string]string `json:"links"`
+}
+
+// BatchItem is the answer for one expression of a batch:
+// where to find it if it was accepted, or what is wrong with it.
+type BatchItem struct {
+	// Index is the position of the expression in the batch, from 0.
+	Index  int               `json:"index"`
+	Id     int               `json:"id,omitempty"`
+	Status string            `json:"status,omitempty"`
+	Links  map[string]string `json:"links,omitempty"`
+	// Error is the same as the error of a 422 from /api/v1/calculate.
+	Error any `json:"error,omitempty"`
+}
+
+// BatchResult is the answer to a batch of expressions, with an item for each of them.
+type BatchResult struct {
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Items    []BatchItem `json:"items"`
 }
 
 type Calculation struct {
 	Task_id    int    `json:"task_id"`
+	Node_id    int    `json:"node_id"`
 	RPN_string string `json:"RPN_string"`
 	Status     string `json:"status"`
-	Result     int    `json:"result"`
+	Result     string `json:"result"`
+	Mode       string `json:"mode"`
+}
+
+type Agent struct {
+	Id             string `json:"id"`
+	Hostname       string `json:"hostname"`
+	ComputingPower int    `json:"computing_power"`
+	FreeSlots      int    `json:"free_slots"`
+	InFlight       int    `json:"in_flight"`
+	LastSeen       string `json:"last_seen"`
+	Alive          bool   `json:"alive"`
 }
 
 type User struct {
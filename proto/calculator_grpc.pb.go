@@ -24,6 +24,11 @@ const _ = grpc.SupportPackageIsVersion7
 type CalculatorServiceClient interface {
 	GetCalculation(ctx context.Context, in *GetCalculationRequest, opts ...grpc.CallOption) (*GetCalculationResponse, error)
 	SendCalculation(ctx context.Context, in *SendCalculationRequest, opts ...grpc.CallOption) (*SendCalculationResponse, error)
+	// The agent keeps the session open and tells how many workers got free,
+	// the orchestrator pushes calculations as soon as they are ready.
+	WorkSession(ctx context.Context, opts ...grpc.CallOption) (CalculatorService_WorkSessionClient, error)
+	RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error)
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
 }
 
 type calculatorServiceClient struct {
@@ -52,12 +57,66 @@ func (c *calculatorServiceClient) SendCalculation(ctx context.Context, in *SendC
 	return out, nil
 }
 
+func (c *calculatorServiceClient) WorkSession(ctx context.Context, opts ...grpc.CallOption) (CalculatorService_WorkSessionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CalculatorService_ServiceDesc.Streams[0], "/calculator.CalculatorService/workSession", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &calculatorServiceWorkSessionClient{stream}
+	return x, nil
+}
+
+type CalculatorService_WorkSessionClient interface {
+	Send(*WorkSessionRequest) error
+	Recv() (*WorkSessionResponse, error)
+	grpc.ClientStream
+}
+
+type calculatorServiceWorkSessionClient struct {
+	grpc.ClientStream
+}
+
+func (x *calculatorServiceWorkSessionClient) Send(m *WorkSessionRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *calculatorServiceWorkSessionClient) Recv() (*WorkSessionResponse, error) {
+	m := new(WorkSessionResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *calculatorServiceClient) RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error) {
+	out := new(RegisterAgentResponse)
+	err := c.cc.Invoke(ctx, "/calculator.CalculatorService/registerAgent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, "/calculator.CalculatorService/heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CalculatorServiceServer is the server API for CalculatorService service.
 // All implementations must embed UnimplementedCalculatorServiceServer
 // for forward compatibility
 type CalculatorServiceServer interface {
 	GetCalculation(context.Context, *GetCalculationRequest) (*GetCalculationResponse, error)
 	SendCalculation(context.Context, *SendCalculationRequest) (*SendCalculationResponse, error)
+	// The agent keeps the session open and tells how many workers got free,
+	// the orchestrator pushes calculations as soon as they are ready.
+	WorkSession(CalculatorService_WorkSessionServer) error
+	RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error)
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
 	mustEmbedUnimplementedCalculatorServiceServer()
 }
 
@@ -71,6 +130,15 @@ func (UnimplementedCalculatorServiceServer) GetCalculation(context.Context, *Get
 func (UnimplementedCalculatorServiceServer) SendCalculation(context.Context, *SendCalculationRequest) (*SendCalculationResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendCalculation not implemented")
 }
+func (UnimplementedCalculatorServiceServer) WorkSession(CalculatorService_WorkSessionServer) error {
+	return status.Errorf(codes.Unimplemented, "method WorkSession not implemented")
+}
+func (UnimplementedCalculatorServiceServer) RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterAgent not implemented")
+}
+func (UnimplementedCalculatorServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
 func (UnimplementedCalculatorServiceServer) mustEmbedUnimplementedCalculatorServiceServer() {}
 
 // UnsafeCalculatorServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +188,68 @@ func _CalculatorService_SendCalculation_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CalculatorService_WorkSession_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CalculatorServiceServer).WorkSession(&calculatorServiceWorkSessionServer{stream})
+}
+
+type CalculatorService_WorkSessionServer interface {
+	Send(*WorkSessionResponse) error
+	Recv() (*WorkSessionRequest, error)
+	grpc.ServerStream
+}
+
+type calculatorServiceWorkSessionServer struct {
+	grpc.ServerStream
+}
+
+func (x *calculatorServiceWorkSessionServer) Send(m *WorkSessionResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *calculatorServiceWorkSessionServer) Recv() (*WorkSessionRequest, error) {
+	m := new(WorkSessionRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CalculatorService_RegisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).RegisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/calculator.CalculatorService/registerAgent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).RegisterAgent(ctx, req.(*RegisterAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalculatorService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/calculator.CalculatorService/heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CalculatorService_ServiceDesc is the grpc.ServiceDesc for CalculatorService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,7 +265,22 @@ var CalculatorService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "sendCalculation",
 			Handler:    _CalculatorService_SendCalculation_Handler,
 		},
+		{
+			MethodName: "registerAgent",
+			Handler:    _CalculatorService_RegisterAgent_Handler,
+		},
+		{
+			MethodName: "heartbeat",
+			Handler:    _CalculatorService_Heartbeat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "workSession",
+			Handler:       _CalculatorService_WorkSession_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "calculator.proto",
 }
@@ -1,8 +1,10 @@
 
 
-// Generate a unique ID for each expression
-function generateId() {
-    return Math.floor(Math.random() * 1000000);
+// Makes a value safe to put into innerHTML, it's shown as plain text.
+function escapeHTML(value) {
+    const element = document.createElement('span');
+    element.textContent = String(value ?? '');
+    return element.innerHTML;
 }
 
 // Function to fetch and display tasks
@@ -10,7 +12,8 @@ async function getTasks() {
     const tasksContainer = document.getElementById('tasks-container');
 
     try {
-        const response = await fetch('/api/v1/expressions');
+        // Only the newest ones, the list is paginated anyway.
+        const response = await fetch('/api/v1/expressions?sort=-id&limit=100');
         
         if (response.status === 401) {
             // Redirect to login or registration page
@@ -43,17 +46,28 @@ async function getTasks() {
                 if (resultElement.innerText !== `Результат: ${task.result}`) {
                     resultElement.innerText = `Результат: ${task.result}`;
                 }
+                // Only expressions that are still being calculated can be cancelled
+                const cancelButton = taskElement.querySelector('.cancel');
+                if (cancelButton && task.status !== 'In Process') {
+                    cancelButton.remove();
+                }
             } else {
                 // Create new task element
                 taskElement = document.createElement('div');
                 taskElement.className = 'task';
                 taskElement.setAttribute('data-id', task.id);
                 taskElement.innerHTML = `
-                    <p>ID Выражения: ${task.id}</p>
-                    <p class="status ${task.status === 'Finished' ? 'finished' : ''}">Состояние: ${task.status}</p>
-                    <p class="expression">Выражение: ${task.original_expression}</p>
-                    <p class="result">Результат: ${task.result}</p>
+                    <p>ID Выражения: ${escapeHTML(task.id)}</p>
+                    <p class="status ${task.status === 'Finished' ? 'finished' : ''}">Состояние: ${escapeHTML(task.status)}</p>
+                    <p class="expression">Выражение: ${escapeHTML(task.original_expression)}</p>
+                    ${task.optimized_expression && task.optimized_expression !== task.original_expression ? `<p class="optimized">Упрощено до: ${escapeHTML(task.optimized_expression)}</p>` : ''}
+                    <p class="result">Результат: ${escapeHTML(task.result)}</p>
+                    ${task.status === 'In Process' ? '<button class="cancel">Отменить</button>' : ''}
                 `;
+                const cancelButton = taskElement.querySelector('.cancel');
+                if (cancelButton) {
+                    cancelButton.addEventListener('click', () => cancelTask(task.id));
+                }
                 // Insert the new task at the beginning
                 tasksContainer.insertBefore(taskElement, tasksContainer.firstChild);
             }
@@ -74,6 +88,19 @@ async function getTasks() {
     }
 }
 
+// Function to cancel an expression that is still being calculated
+async function cancelTask(id) {
+    try {
+        const response = await fetch(`/api/v1/expressions/${id}`, { method: 'DELETE' });
+        if (!response.ok) {
+            console.error('Error cancelling task:', response.status);
+        }
+        getTasks();
+    } catch (error) {
+        console.error('Error cancelling task:', error);
+    }
+}
+
 // Function to fetch and display the username
 async function displayUsername() {
     try {
@@ -107,11 +134,12 @@ document.querySelector('form').addEventListener('submit', async (event) => {
     const tasksContainer = document.getElementById('tasks-container');
     const expressionInput = document.getElementById('expression');
     const expression = expressionInput.value;
-    const id = generateId();
-
+    const mode = document.getElementById('mode').value;
+    const optimize = document.getElementById('optimize').checked;
     const data = {
-        id: id,
-        expression: expression
+        expression: expression,
+        mode: mode,
+        optimize: optimize
     };
 
     try {
@@ -128,6 +156,23 @@ document.querySelector('form').addEventListener('submit', async (event) => {
             const errorMessage = document.createElement('div');
             errorMessage.className = 'error';
             errorMessage.innerText = `Ошибка: Не удалось добавить выражение (статус: ${response.status}).`;
+
+            // A 422 with JSON tells exactly what is wrong and where, so point at it.
+            // Errors of the request itself, like an unknown mode, have no position.
+            let error = null;
+            if (response.status === 422 && response.headers.get('Content-Type') === 'application/json') {
+                ({ error } = await response.json());
+                errorMessage.innerText = `Ошибка: ${error.message}.`;
+            }
+            if (error && error.offset !== undefined) {
+                const chars = Array.from(expression);
+                errorMessage.innerText = `Ошибка: ${error.message} (позиция ${error.offset}).\n${expression}\n${' '.repeat(error.offset)}^`;
+                const start = chars.slice(0, error.offset).join('').length;
+                const end = start + (error.token ? error.token.length : 0);
+                expressionInput.focus();
+                expressionInput.setSelectionRange(start, Math.max(end, start + 1));
+            }
+
             tasksContainer.insertBefore(errorMessage, tasksContainer.firstChild);
         } else {
             expressionInput.value = '';